@@ -19,7 +19,6 @@ select on `end` to make sure it stops in a timely fashion.
 package ircevent
 
 import (
-	"bytes"
 	"context"
 	"crypto/tls"
 	"errors"
@@ -43,10 +42,12 @@ const (
 
 	maxlenTags = 8192
 
-	writeQueueSize = 10
+	defaultSendQueueLen = 10
 
 	defaultNick = "ircevent"
 
+	defaultReconnectFreq = 2 * time.Minute
+
 	CAPTimeout = time.Second * 15
 )
 
@@ -82,12 +83,12 @@ func (irc *Connection) getError() error {
 
 // Send a keepalive PING in our timestamp-based format
 func (irc *Connection) ping() {
-	param := fmt.Sprintf("%s%d", keepalivePrefix, time.Now().UnixNano())
-	irc.Send("PING", param)
+	irc.Send("PING", keepaliveParam(time.Now()))
 }
 
 // Interpret the PONG from a keepalive ping
-func (irc *Connection) recordPong(param string) {
+func (irc *Connection) recordPong(e ircmsg.Message) {
+	param := lastParam(&e)
 	ts := strings.TrimPrefix(param, keepalivePrefix)
 	if ts == param {
 		return
@@ -96,14 +97,34 @@ func (irc *Connection) recordPong(param string) {
 	if err != nil {
 		return
 	}
-	if irc.Debug {
-		pong := time.Unix(0, t)
-		irc.Log.Printf("Lag: %v\n", time.Since(pong))
+	now := time.Now()
+	pingSentAt := time.Unix(0, t)
+	sample := keepaliveSample{receivedAt: now, rtt: now.Sub(pingSentAt)}
+	irc.log(LogLevelDebug, "lag", []LogAttr{{"lag", sample.rtt}}, "Lag: %v\n", sample.rtt)
+	if irc.Metrics != nil {
+		irc.Metrics.Lag(sample.rtt)
+	}
+	if serverTime, ok := e.ServerTime(); ok {
+		sample.offset = serverTime.Sub(pingSentAt.Add(sample.rtt / 2))
+		sample.hasOffset = true
 	}
 
 	irc.stateMutex.Lock()
-	defer irc.stateMutex.Unlock()
 	irc.pingSent = false
+	irc.missedPongs = 0
+	irc.addKeepaliveSampleNoMutex(sample)
+	waiter := irc.pingWaiters[param]
+	delete(irc.pingWaiters, param)
+	lagCrossed := irc.checkLagThresholdNoMutex(sample.rtt)
+	exceeded := irc.lagExceeded
+	irc.stateMutex.Unlock()
+
+	if waiter != nil {
+		waiter <- sample.rtt
+	}
+	if lagCrossed {
+		irc.runLagCallbacks(sample.rtt, exceeded)
+	}
 }
 
 // Read data from a connection. To be used as a goroutine.
@@ -127,22 +148,26 @@ func (irc *Connection) readLoop() {
 		case <-irc.end:
 			return
 		case msg := <-msgChan:
-			if irc.Debug {
-				irc.Log.Printf("<-- %s\n", strings.TrimSpace(msg))
+			irc.logLine("in", []byte(msg))
+			if irc.Metrics != nil {
+				irc.Metrics.LineReceived(len(msg))
 			}
 
 			parsedMsg, err := ircmsg.ParseLine(msg)
 			if err == nil {
+				irc.setLastReceivedAt(time.Now())
 				irc.runCallbacks(parsedMsg)
 			} else {
-				irc.Log.Printf("invalid message from server: %v\n", err)
+				irc.log(LogLevelWarn, "invalid message from server", []LogAttr{{"error", err}, {"line", msg}},
+					"invalid message from server: %v\n", err)
 			}
 		case err := <-errChan:
 			irc.setError(err)
 			return
 		}
 
-		if irc.batchNegotiated() && time.Since(lastExpireCheck) > irc.Timeout {
+		// labeled responses and batches need batch; unlabeled echoes don't
+		if (irc.batchNegotiated() || irc.echoNegotiated()) && time.Since(lastExpireCheck) > irc.Timeout {
 			irc.expireBatches(false)
 			lastExpireCheck = time.Now()
 		}
@@ -174,30 +199,49 @@ func readMsgLoop(socket net.Conn, maxLineLen int, msgChan chan string, errChan c
 func (irc *Connection) writeLoop() {
 	defer irc.wg.Done()
 
+	queue := irc.sendQueue
 	for {
 		select {
 		case <-irc.end:
 			return
-		case b := <-irc.pwrite:
-			if len(b) == 0 {
+		default:
+		}
+
+		b, dropped, ok := queue.pop(time.Now())
+		if dropped != 0 {
+			irc.log(LogLevelDebug, "dropped expired messages", []LogAttr{{"count", dropped}},
+				"Dropped %d expired messages\n", dropped)
+		}
+		if !ok {
+			select {
+			case <-irc.end:
+				return
+			case <-queue.ready:
 				continue
 			}
+		}
+		if len(b) == 0 {
+			continue
+		}
 
-			if irc.Debug {
-				irc.Log.Printf("--> %s\n", bytes.TrimSpace(b))
-			}
+		irc.logLine("out", b)
+		if irc.Metrics != nil {
+			irc.Metrics.QueueDepth(queue.len())
+		}
 
-			if irc.Timeout != 0 {
-				irc.socket.SetWriteDeadline(time.Now().Add(irc.Timeout))
-			}
-			_, err := irc.socket.Write(b)
-			if irc.Timeout != 0 {
-				irc.socket.SetWriteDeadline(time.Time{})
-			}
-			if err != nil {
-				irc.setError(err)
-				return
-			}
+		if irc.Timeout != 0 {
+			irc.socket.SetWriteDeadline(time.Now().Add(irc.Timeout))
+		}
+		_, err := irc.socket.Write(b)
+		if irc.Timeout != 0 {
+			irc.socket.SetWriteDeadline(time.Time{})
+		}
+		if err != nil {
+			irc.setError(err)
+			return
+		}
+		if irc.Metrics != nil {
+			irc.Metrics.LineSent(len(b))
 		}
 	}
 }
@@ -205,9 +249,13 @@ func (irc *Connection) writeLoop() {
 // check the status of the connection and take appropriate action
 func (irc *Connection) processTick(tick int) {
 	var err error
-	var shouldPing, shouldRenick bool
+	var shouldPing, shouldRenick, lagExceeded bool
+	var lag time.Duration
 
 	defer func() {
+		if lagExceeded {
+			irc.runLagCallbacks(lag, true)
+		}
 		if err != nil {
 			irc.setError(err)
 			return
@@ -229,14 +277,27 @@ func (irc *Connection) processTick(tick int) {
 		return
 	}
 	if irc.pingSent {
-		// unacked PING is fatal
-		err = ServerTimedOut
+		// an unanswered PING counts towards the lag, even before the PONG
+		lag = time.Since(irc.pingSentAt)
+		if irc.LagThreshold > 0 && !irc.lagExceeded && lag > irc.LagThreshold {
+			irc.lagExceeded = true
+			lagExceeded = true
+		}
+		irc.missedPongs++
+		if irc.missedPongs >= irc.MaxMissedPongs {
+			// too many unacked PINGs are fatal
+			err = ServerTimedOut
+			return
+		}
+		// try again
+		shouldPing = true
 		return
 	}
 	pingModulus := int(irc.KeepAlive / irc.Timeout)
 	if tick%pingModulus == 0 {
 		shouldPing = true
 		irc.pingSent = true
+		irc.pingSentAt = time.Now()
 		if irc.currentNick != irc.Nick {
 			shouldRenick = true
 		}
@@ -273,38 +334,56 @@ func (irc *Connection) isQuitting() bool {
 	return irc.quit
 }
 
+func (irc *Connection) getQuitSigNoMutex() chan empty {
+	if irc.quitSig == nil {
+		irc.quitSig = make(chan empty)
+	}
+	return irc.quitSig
+}
+
 // Main loop to control the connection.
 func (irc *Connection) Loop() {
 	var lastReconnect time.Time
+	irc.stateMutex.Lock()
+	quitSig := irc.getQuitSigNoMutex()
+	irc.stateMutex.Unlock()
 	for {
 		irc.waitForStop()
+		// if Connect() failed, the state is already StateDisconnected:
+		irc.setState(StateDisconnected, irc.getError())
 
 		if irc.isQuitting() {
 			return
 		}
 
 		if err := irc.getError(); err != nil {
-			irc.Log.Printf("Error, disconnected: %s\n", err)
+			irc.log(LogLevelError, "disconnected", []LogAttr{{"error", err}}, "Error, disconnected: %s\n", err)
 		}
+		irc.setState(StateWaitingToReconnect, nil)
 
 		delay := time.Until(lastReconnect.Add(irc.ReconnectFreq))
 		if delay > 0 {
-			if irc.Debug {
-				irc.Log.Printf("Waiting %v to reconnect", delay)
-			}
+			irc.log(LogLevelDebug, "waiting to reconnect", []LogAttr{{"delay", delay}}, "Waiting %v to reconnect", delay)
 			t := time.NewTimer(delay)
 			select {
 			case <-t.C:
 			case <-irc.reconnSig:
 				t.Stop()
+			case <-quitSig:
+				t.Stop()
+				irc.setState(StateDisconnected, nil)
+				return
 			}
 		}
 
 		lastReconnect = time.Now()
+		if irc.Metrics != nil {
+			irc.Metrics.Reconnecting()
+		}
 		err := irc.Connect()
 		if err != nil {
 			// we are still stopped, the stop checks will return immediately
-			irc.Log.Printf("Error while reconnecting: %s\n", err)
+			irc.log(LogLevelError, "error while reconnecting", []LogAttr{{"error", err}}, "Error while reconnecting: %s\n", err)
 		}
 	}
 }
@@ -320,6 +399,18 @@ func (irc *Connection) waitForStop() {
 		irc.socket.Close()
 	}
 
+	// only messages sent after registration are resent; if Connect() failed,
+	// the ones left over are part of the failed registration:
+	irc.stateMutex.Lock()
+	resend := irc.ResendOnReconnect && irc.registered && !irc.quit && irc.sendQueue != nil
+	irc.stateMutex.Unlock()
+	if resend {
+		unsent := irc.sendQueue.takeUnsent()
+		irc.stateMutex.Lock()
+		irc.unsent = append(irc.unsent, unsent...)
+		irc.stateMutex.Unlock()
+	}
+
 	irc.expireBatches(true)
 }
 
@@ -333,17 +424,33 @@ func (irc *Connection) Quit() {
 
 	now := time.Now()
 	irc.stateMutex.Lock()
+	wasQuitting := irc.quit
 	irc.quit = true
 	irc.quitAt = now
+	quitSig := irc.getQuitSigNoMutex()
 	irc.stateMutex.Unlock()
+	irc.setState(StateQuitting, nil)
+	if !wasQuitting {
+		// interrupt Loop if it's waiting to reconnect
+		close(quitSig)
+	}
 
 	// the server will respond to this by closing our connection;
 	// if it doesn't, pingLoop will eventually notice and close it
 	irc.Send("QUIT", quitMessage)
 }
 
-func (irc *Connection) sendInternal(b []byte) (err error) {
-	// XXX ensure that (end, pwrite) are from the same instantiation of Connect;
+// QuitWithFlush is like Quit, but first waits (for up to timeout) for any
+// queued messages to be sent; since QUIT is a control message, Quit sends
+// it ahead of them. It returns the error from Flush, if any.
+func (irc *Connection) QuitWithFlush(timeout time.Duration) (err error) {
+	err = irc.Flush(timeout)
+	irc.Quit()
+	return
+}
+
+func (irc *Connection) sendInternal(b []byte, options SendOptions) (err error) {
+	// XXX ensure that (end, sendQueue) are from the same instantiation of Connect;
 	// invocations of this function from callbacks originating in readLoop
 	// do not need this synchronization (indeed they cannot occur at a time when
 	// `end` is closed), but invocations from outside do (even though the race window
@@ -351,31 +458,29 @@ func (irc *Connection) sendInternal(b []byte) (err error) {
 	irc.stateMutex.Lock()
 	running := irc.running
 	end := irc.end
-	pwrite := irc.pwrite
+	queue := irc.sendQueue
 	irc.stateMutex.Unlock()
 
 	if !running {
 		return ClientDisconnected
 	}
 
-	select {
-	case pwrite <- b:
-		return nil
-	case <-end:
-		return ClientDisconnected
-	}
+	return queue.push(b, options, end)
 }
 
 // Send a built ircmsg.Message.
 func (irc *Connection) SendIRCMessage(msg ircmsg.Message) error {
+	return irc.sendIRCMessage(msg, SendOptions{})
+}
+
+func (irc *Connection) sendIRCMessage(msg ircmsg.Message, options SendOptions) error {
 	b, err := msg.LineBytesStrict(true, irc.MaxLineLen)
 	if err != nil && !(irc.AllowTruncation && err == ircmsg.ErrorBodyTooLong) {
-		if irc.Debug {
-			irc.Log.Printf("couldn't assemble message: %v\n", err)
-		}
+		irc.log(LogLevelDebug, "couldn't assemble message", []LogAttr{{"command", msg.Command}, {"error", err}},
+			"couldn't assemble message: %v\n", err)
 		return err
 	}
-	return irc.sendInternal(b)
+	return irc.sendInternal(b, options)
 }
 
 // Send an IRC message with tags.
@@ -412,7 +517,8 @@ func (irc *Connection) SendWithLabel(callback func(*Batch), tags map[string]stri
 // GetLabeledResponse sends an IRC message using the IRCv3 labeled-response
 // specification, then synchronously waits for the response, which is returned
 // as a *Batch. If the server fails to respond correctly, an error will be
-// returned.
+// returned. If the response contains a FAIL, the batch is returned together
+// with the FAIL as a StandardReply error.
 func (irc *Connection) GetLabeledResponse(tags map[string]string, command string, params ...string) (batch *Batch, err error) {
 	done := make(chan empty)
 	err = irc.SendWithLabel(func(b *Batch) {
@@ -425,6 +531,8 @@ func (irc *Connection) GetLabeledResponse(tags map[string]string, command string
 	<-done
 	if batch == nil {
 		err = NoLabeledResponse
+	} else if fail, ok := findFail(batch); ok {
+		err = fail
 	}
 	return
 }
@@ -435,7 +543,7 @@ func (irc *Connection) SendRaw(message string) error {
 	buf := make([]byte, mlen+2)
 	copy(buf[:mlen], message[:])
 	copy(buf[mlen:], "\r\n")
-	return irc.sendInternal(buf)
+	return irc.sendInternal(buf, SendOptions{})
 }
 
 // Use the connection to join a given channel.
@@ -474,9 +582,12 @@ func (irc *Connection) Privmsgf(target, format string, a ...interface{}) error {
 }
 
 // Send (action) message to a target (channel or nickname).
-// No clear RFC on this one...
+// No clear RFC on this one... The message is sent as a CTCP ACTION via
+// SendCTCP, so a message containing NUL, CR or LF is rejected with
+// ircmsg.ErrorLineContainsBadChar (as is one containing \x01, with
+// ircmsg.ErrorBadCTCP).
 func (irc *Connection) Action(target, message string) error {
-	return irc.Privmsg(target, fmt.Sprintf("\001ACTION %s\001", message))
+	return irc.SendCTCP(target, "ACTION", message)
 }
 
 // Send formatted (action) message to a target (channel or nickname).
@@ -542,8 +653,8 @@ func (irc *Connection) Connected() bool {
 	return irc.running
 }
 
-// Reconnect forces the client to reconnect to the server.
-// TODO try to ensure buffered messages are sent?
+// Reconnect forces the client to reconnect to the server. Any queued messages
+// are discarded, unless ResendOnReconnect is set; see also ReconnectWithFlush.
 func (irc *Connection) Reconnect() {
 	irc.closeEnd()
 	select {
@@ -552,6 +663,28 @@ func (irc *Connection) Reconnect() {
 	}
 }
 
+// ReconnectWithFlush is like Reconnect, but first waits (for up to timeout)
+// for any queued messages to be sent. It returns the error from Flush, if any.
+func (irc *Connection) ReconnectWithFlush(timeout time.Duration) (err error) {
+	err = irc.Flush(timeout)
+	irc.Reconnect()
+	return
+}
+
+// resendUnsent queues the messages left unsent by the previous connection
+// (see ResendOnReconnect) on the current one.
+func (irc *Connection) resendUnsent() {
+	irc.stateMutex.Lock()
+	unsent := irc.unsent
+	irc.unsent = nil
+	queue := irc.sendQueue
+	irc.stateMutex.Unlock()
+
+	if len(unsent) != 0 {
+		queue.requeue(unsent)
+	}
+}
+
 func (irc *Connection) closeEnd() {
 	irc.stateMutex.Lock()
 	defer irc.stateMutex.Unlock()
@@ -591,6 +724,7 @@ func (irc *Connection) dial() (socket net.Conn, err error) {
 			irc.TLSConfig.ServerName = irc.Server
 		}
 	}
+	irc.setState(StateTLSHandshake, nil)
 	tlsSocket := tls.Client(socket, irc.TLSConfig)
 	err = tlsSocket.HandshakeContext(ctx)
 	if err != nil {
@@ -608,6 +742,12 @@ func (irc *Connection) Connect() (err error) {
 	// (a) success: return nil, socket open, goroutines launched, ready for Loop
 	// (b) failure: return error, socket closed, goroutines stopped,
 	//     ready for another call to Connect (possibly from Loop)
+	defer func() {
+		if err != nil {
+			irc.setState(StateDisconnected, err)
+		}
+	}()
+
 	err = func() error {
 		irc.stateMutex.Lock()
 		defer irc.stateMutex.Unlock()
@@ -622,6 +762,8 @@ func (irc *Connection) Connect() (err error) {
 		irc.currentNick = ""
 		irc.lastError = nil
 		irc.pingSent = false
+		irc.missedPongs = 0
+		irc.lagExceeded = false
 
 		if irc.Server == "" {
 			return errors.New("No server provided")
@@ -644,8 +786,11 @@ func (irc *Connection) Connect() (err error) {
 		if irc.KeepAlive < irc.Timeout {
 			return errors.New("KeepAlive must be at least Timeout")
 		}
+		if irc.MaxMissedPongs == 0 {
+			irc.MaxMissedPongs = 1
+		}
 		if irc.ReconnectFreq == 0 {
-			irc.ReconnectFreq = 2 * time.Minute
+			irc.ReconnectFreq = defaultReconnectFreq
 		}
 		if irc.SASLLogin != "" && irc.SASLPassword != "" {
 			irc.UseSASL = true
@@ -656,6 +801,11 @@ func (irc *Connection) Connect() (err error) {
 				irc.RequestCaps = append(irc.RequestCaps, "sasl")
 			}
 		}
+		if irc.BouncerNetID != "" {
+			if !sliceContains(BouncerNetworksCap, irc.RequestCaps) {
+				irc.RequestCaps = append(irc.RequestCaps, BouncerNetworksCap)
+			}
+		}
 		if irc.SASLMech == "" {
 			irc.SASLMech = "PLAIN"
 		}
@@ -665,9 +815,15 @@ func (irc *Connection) Connect() (err error) {
 		if irc.MaxLineLen == 0 {
 			irc.MaxLineLen = 512
 		}
+		if irc.SendQueueLen <= 0 {
+			irc.SendQueueLen = defaultSendQueueLen
+		}
 		if irc.Version == "" {
 			irc.Version = Version
 		}
+		if irc.CTCPReplyInterval == 0 {
+			irc.CTCPReplyInterval = defaultCTCPReplyInterval
+		}
 		// this only runs on first Connect() invocation;
 		// unlike other synch primitives it is shared across reconnections:
 		if irc.reconnSig == nil {
@@ -682,25 +838,23 @@ func (irc *Connection) Connect() (err error) {
 
 	irc.setupCallbacks()
 
-	if irc.Debug {
-		irc.Log.Printf("Connecting to %s (TLS: %t)\n", irc.Server, irc.UseTLS)
-	}
+	irc.log(LogLevelDebug, "connecting", []LogAttr{{"tls", irc.UseTLS}}, "Connecting to %s (TLS: %t)\n", irc.Server, irc.UseTLS)
+	irc.setState(StateDialing, nil)
 
 	socket, err := irc.dial()
 	if err != nil {
 		return err
 	}
 
-	if irc.Debug {
-		irc.Log.Printf("Connected to %s (%s)\n", irc.Server, socket.RemoteAddr())
-	}
+	irc.log(LogLevelDebug, "connected", []LogAttr{{"address", socket.RemoteAddr().String()}},
+		"Connected to %s (%s)\n", irc.Server, socket.RemoteAddr())
 
 	// reset all connection state
 	irc.stateMutex.Lock()
 	irc.socket = socket
 	irc.running = true
 	irc.end = make(chan empty)
-	irc.pwrite = make(chan []byte, writeQueueSize)
+	irc.sendQueue = newSendQueue(irc.SendQueueLen)
 	irc.wg.Add(3)
 	irc.capsChan = make(chan capResult, len(irc.RequestCaps))
 	irc.saslChan = make(chan saslResult, 1)
@@ -710,11 +864,13 @@ func (irc *Connection) Connect() (err error) {
 	irc.isupport = nil
 	irc.capsAcked = make(map[string]string)
 	irc.capsAdvertised = nil
+	irc.keepaliveSamples = nil
 	irc.stateMutex.Unlock()
 	irc.batchMutex.Lock()
 	irc.batches = make(map[string]batchInProgress)
 	irc.labelCallbacks = make(map[int64]pendingLabel)
 	irc.labelCounter = 0
+	irc.pendingEchoes = nil
 	irc.batchMutex.Unlock()
 
 	go irc.readLoop()
@@ -747,6 +903,7 @@ func (irc *Connection) Connect() (err error) {
 	if irc.RealName != "" {
 		realname = irc.RealName
 	}
+	irc.setState(StateRegistering, nil)
 	irc.Send("NICK", irc.PreferredNick())
 	irc.Send("USER", irc.User, "s", "e", realname)
 	timeout := time.NewTimer(irc.Timeout)
@@ -766,6 +923,7 @@ func (irc *Connection) negotiateCaps() error {
 	if len(irc.RequestCaps) == 0 {
 		return nil
 	}
+	irc.setState(StateNegotiatingCaps, nil)
 
 	var acknowledgedCaps []string
 	defer func() {
@@ -808,6 +966,7 @@ func (irc *Connection) negotiateCaps() error {
 		if !sliceContains("sasl", acknowledgedCaps) {
 			return saslError(SASLFailed)
 		} else {
+			irc.setState(StateAuthenticating, nil)
 			irc.Send("AUTHENTICATE", irc.SASLMech)
 		}
 		timeout := time.NewTimer(CAPTimeout)
@@ -826,5 +985,14 @@ func (irc *Connection) negotiateCaps() error {
 		}
 	}
 
+	// binding to a bouncer network must happen after authentication,
+	// but before the end of capability negotiation
+	if irc.BouncerNetID != "" {
+		if !sliceContains(BouncerNetworksCap, acknowledgedCaps) {
+			return CapabilityNotNegotiated
+		}
+		irc.Send("BOUNCER", "BIND", irc.BouncerNetID)
+	}
+
 	return nil
 }
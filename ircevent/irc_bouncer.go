@@ -0,0 +1,179 @@
+package ircevent
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+// Support for the soju.im/bouncer-networks extension, which allows a client
+// of a bouncer to list and manage its upstream networks, and to bind a
+// connection to one of them:
+// https://codeberg.org/emersion/soju/src/branch/master/doc/ext/bouncer-networks.md
+
+const (
+	BouncerNetworksCap       = "soju.im/bouncer-networks"
+	BouncerNetworksNotifyCap = "soju.im/bouncer-networks-notify"
+)
+
+var (
+	UnexpectedResponse = errors.New("The server sent an unexpected response to the command")
+)
+
+// BouncerNetwork is an upstream network of a bouncer.
+type BouncerNetwork struct {
+	ID string
+	// Attributes are the network's attributes, e.g. "name", "host", "nickname"
+	// and "state" (one of "connected", "connecting" or "disconnected").
+	// In a notification, only the changed attributes are included, and an
+	// empty value indicates that the attribute was removed.
+	Attributes map[string]string
+	// Deleted is set in the notification that the network was deleted.
+	Deleted bool
+}
+
+// Name returns the network's name attribute.
+func (network *BouncerNetwork) Name() string {
+	return network.Attributes["name"]
+}
+
+// State returns the network's state attribute.
+func (network *BouncerNetwork) State() string {
+	return network.Attributes["state"]
+}
+
+func parseBouncerAttributes(str string) (attributes map[string]string) {
+	attributes = make(map[string]string)
+	for _, attr := range strings.Split(str, ";") {
+		if attr == "" {
+			continue
+		}
+		if equalsIdx := strings.IndexByte(attr, '='); equalsIdx == -1 {
+			attributes[attr] = ""
+		} else {
+			attributes[attr[:equalsIdx]] = ircmsg.UnescapeTagValue(attr[equalsIdx+1:])
+		}
+	}
+	return
+}
+
+func serializeBouncerAttributes(attributes map[string]string) string {
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	// sort for a deterministic result
+	sort.Strings(names)
+	var buf strings.Builder
+	for i, name := range names {
+		if i != 0 {
+			buf.WriteByte(';')
+		}
+		buf.WriteString(name)
+		buf.WriteByte('=')
+		buf.WriteString(ircmsg.EscapeTagValue(attributes[name]))
+	}
+	return buf.String()
+}
+
+// parseBouncerNetwork parses BOUNCER NETWORK <netid> <attributes>
+func parseBouncerNetwork(msg *ircmsg.Message) (network BouncerNetwork, ok bool) {
+	if !(msg.Command == "BOUNCER" && len(msg.Params) >= 3 && msg.Params[0] == "NETWORK") {
+		return
+	}
+	network.ID = msg.Params[1]
+	if msg.Params[2] == "*" {
+		network.Deleted = true
+	} else {
+		network.Attributes = parseBouncerAttributes(msg.Params[2])
+	}
+	return network, true
+}
+
+func (irc *Connection) bouncerCommand(params ...string) (batch *Batch, err error) {
+	if _, ok := irc.AcknowledgedCaps()[BouncerNetworksCap]; !ok {
+		return nil, CapabilityNotNegotiated
+	}
+	batch, err = irc.GetLabeledResponse(nil, "BOUNCER", params...)
+	if err != nil {
+		return
+	}
+	if numeric := findInBatch(batch, func(msg *ircmsg.Message) bool { return isErrorNumeric(msg.Command) }); numeric != nil {
+		return batch, NumericError{Message: *numeric}
+	}
+	return
+}
+
+// bouncerReply finds BOUNCER <subcommand> <netid> in a response.
+func bouncerReply(batch *Batch, subcommand string) (netid string, err error) {
+	reply := findInBatch(batch, func(msg *ircmsg.Message) bool {
+		return msg.Command == "BOUNCER" && len(msg.Params) >= 2 && msg.Params[0] == subcommand
+	})
+	if reply == nil {
+		return "", UnexpectedResponse
+	}
+	return reply.Params[1], nil
+}
+
+// ListBouncerNetworks returns the bouncer's upstream networks. Like the other
+// bouncer methods, it requires the soju.im/bouncer-networks and
+// labeled-response capabilities; if the bouncer rejects the command, the
+// error is a StandardReply (FAIL) or a NumericError.
+func (irc *Connection) ListBouncerNetworks() (networks []BouncerNetwork, err error) {
+	batch, err := irc.bouncerCommand("LISTNETWORKS")
+	if err != nil {
+		return
+	}
+	findInBatch(batch, func(msg *ircmsg.Message) bool {
+		if network, ok := parseBouncerNetwork(msg); ok && !network.Deleted {
+			networks = append(networks, network)
+		}
+		return false
+	})
+	return
+}
+
+// AddBouncerNetwork adds an upstream network with the given attributes
+// (at least "host" is required), returning its ID.
+func (irc *Connection) AddBouncerNetwork(attributes map[string]string) (netid string, err error) {
+	batch, err := irc.bouncerCommand("ADDNETWORK", serializeBouncerAttributes(attributes))
+	if err != nil {
+		return
+	}
+	return bouncerReply(batch, "ADDNETWORK")
+}
+
+// ChangeBouncerNetwork changes the attributes of an upstream network;
+// attributes that are not included are unchanged.
+func (irc *Connection) ChangeBouncerNetwork(netid string, attributes map[string]string) (err error) {
+	batch, err := irc.bouncerCommand("CHANGENETWORK", netid, serializeBouncerAttributes(attributes))
+	if err != nil {
+		return
+	}
+	_, err = bouncerReply(batch, "CHANGENETWORK")
+	return
+}
+
+// DeleteBouncerNetwork deletes an upstream network.
+func (irc *Connection) DeleteBouncerNetwork(netid string) (err error) {
+	batch, err := irc.bouncerCommand("DELNETWORK", netid)
+	if err != nil {
+		return
+	}
+	_, err = bouncerReply(batch, "DELNETWORK")
+	return
+}
+
+// AddBouncerNetworkCallback adds a callback for notifications that a bouncer
+// network was added, changed or deleted (these require the
+// soju.im/bouncer-networks-notify capability). It can be removed as usual
+// with RemoveCallback.
+func (irc *Connection) AddBouncerNetworkCallback(callback func(BouncerNetwork)) CallbackID {
+	return irc.AddCallback("BOUNCER", func(e ircmsg.Message) {
+		if network, ok := parseBouncerNetwork(&e); ok {
+			callback(network)
+		}
+	})
+}
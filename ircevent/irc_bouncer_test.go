@@ -0,0 +1,267 @@
+package ircevent
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+// fakeBouncer implements just enough of soju.im/bouncer-networks
+// to test the client side of it.
+type fakeBouncer struct {
+	listener net.Listener
+
+	mutex    sync.Mutex
+	boundTo  string
+	networks map[string]map[string]string
+	nextID   int
+	received []string // lines received from clients
+	failures []string // protocol violations by clients
+}
+
+func newFakeBouncer(t *testing.T) *fakeBouncer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bouncer := &fakeBouncer{
+		listener: listener,
+		networks: map[string]map[string]string{
+			"1": {"name": "Libera", "host": "irc.libera.chat", "state": "connected"},
+			"2": {"name": "OFTC; the other one", "host": "irc.oftc.net", "state": "disconnected"},
+		},
+		nextID: 3,
+	}
+	go bouncer.serve()
+	return bouncer
+}
+
+func (b *fakeBouncer) serve() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		go b.handle(conn)
+	}
+}
+
+func (b *fakeBouncer) handle(conn net.Conn) {
+	defer conn.Close()
+	send := func(line string) {
+		io.WriteString(conn, line+"\r\n")
+	}
+	var nick string
+	// registration is held until CAP END, if capability negotiation started:
+	var capNegotiating, capEnded, userReceived bool
+	welcome := func() {
+		send(":bouncer 001 " + nick + " :Welcome")
+		send(":bouncer 376 " + nick + " :End of MOTD")
+	}
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		b.mutex.Lock()
+		b.received = append(b.received, line)
+		b.mutex.Unlock()
+		msg, err := ircmsg.ParseLine(line)
+		if err != nil {
+			continue
+		}
+		label := ""
+		if present, value := msg.GetTag("label"); present {
+			label = "@label=" + value + " "
+		}
+		switch msg.Command {
+		case "CAP":
+			switch msg.Params[0] {
+			case "LS":
+				capNegotiating = true
+				send(":bouncer CAP * LS :batch labeled-response message-tags sasl " + BouncerNetworksCap + " " + BouncerNetworksNotifyCap)
+			case "REQ":
+				send(":bouncer CAP * ACK :" + msg.Params[1])
+			case "END":
+				if capNegotiating && !capEnded {
+					capEnded = true
+					if userReceived {
+						welcome()
+					}
+				}
+			}
+		case "AUTHENTICATE":
+			if msg.Params[0] == "PLAIN" {
+				send("AUTHENTICATE +")
+			} else {
+				send(":bouncer 903 * :SASL authentication successful")
+			}
+		case "NICK":
+			nick = msg.Params[0]
+		case "USER":
+			userReceived = true
+			if !capNegotiating || capEnded {
+				welcome()
+			}
+		case "PING":
+			send(":bouncer PONG bouncer " + msg.Params[0])
+		case "QUIT":
+			send("ERROR :Goodbye")
+			return
+		case "BOUNCER":
+			b.handleBouncer(msg, label, capEnded || (userReceived && !capNegotiating), send)
+		}
+	}
+}
+
+func (b *fakeBouncer) handleBouncer(msg ircmsg.Message, label string, registered bool, send func(string)) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	switch msg.Params[0] {
+	case "BIND":
+		// BIND is only valid during registration, before CAP END:
+		if registered {
+			b.failures = append(b.failures, "BOUNCER BIND after registration")
+			send(label + ":bouncer FAIL BOUNCER REGISTRATION_IS_COMPLETED BIND :Cannot bind after registration")
+			return
+		}
+		b.boundTo = msg.Params[1]
+	case "LISTNETWORKS":
+		send(label + ":bouncer BATCH +nets soju.im/bouncer-networks")
+		for _, id := range []string{"1", "2", "3"} {
+			if attrs, ok := b.networks[id]; ok {
+				send("@batch=nets :bouncer BOUNCER NETWORK " + id + " " + serializeBouncerAttributes(attrs))
+			}
+		}
+		send(":bouncer BATCH -nets")
+	case "ADDNETWORK":
+		id := fmt.Sprintf("%d", b.nextID)
+		b.nextID++
+		b.networks[id] = parseBouncerAttributes(msg.Params[1])
+		send(label + ":bouncer BOUNCER ADDNETWORK " + id)
+		send(":bouncer BOUNCER NETWORK " + id + " " + msg.Params[1])
+	case "CHANGENETWORK":
+		if _, ok := b.networks[msg.Params[1]]; !ok {
+			send(label + ":bouncer FAIL BOUNCER INVALID_NETID CHANGENETWORK " + msg.Params[1] + " :Invalid network ID")
+			return
+		}
+		for name, value := range parseBouncerAttributes(msg.Params[2]) {
+			b.networks[msg.Params[1]][name] = value
+		}
+		send(label + ":bouncer BOUNCER CHANGENETWORK " + msg.Params[1])
+		send(":bouncer BOUNCER NETWORK " + msg.Params[1] + " " + msg.Params[2])
+	case "DELNETWORK":
+		delete(b.networks, msg.Params[1])
+		send(label + ":bouncer BOUNCER DELNETWORK " + msg.Params[1])
+		send(":bouncer BOUNCER NETWORK " + msg.Params[1] + " *")
+	default:
+		send(label + ":bouncer FAIL BOUNCER UNKNOWN_COMMAND " + msg.Params[0] + " :Unknown command")
+	}
+}
+
+func (b *fakeBouncer) getBoundTo() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.boundTo
+}
+
+func (b *fakeBouncer) getFailures() []string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]string(nil), b.failures...)
+}
+
+func (b *fakeBouncer) getReceived() []string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]string(nil), b.received...)
+}
+
+func TestBouncerAttributes(t *testing.T) {
+	attrs := map[string]string{"name": "a;b c", "host": "irc.example.com", "tls": ""}
+	assertEqual(serializeBouncerAttributes(attrs), `host=irc.example.com;name=a\:b\sc;tls=`)
+	assertEqual(parseBouncerAttributes(serializeBouncerAttributes(attrs)), attrs)
+	assertEqual(parseBouncerAttributes("state;name=x"), map[string]string{"state": "", "name": "x"})
+}
+
+func TestBouncerNetworks(t *testing.T) {
+	bouncer := newFakeBouncer(t)
+	defer bouncer.listener.Close()
+
+	irc := &Connection{
+		Server:       bouncer.listener.Addr().String(),
+		Nick:         "bot",
+		RequestCaps:  []string{"batch", "labeled-response", "message-tags", BouncerNetworksNotifyCap},
+		BouncerNetID: "1",
+		Timeout:      5 * time.Second,
+		Log:          log.New(io.Discard, "", 0),
+	}
+	notifications := make(chan BouncerNetwork, 8)
+	irc.AddBouncerNetworkCallback(func(network BouncerNetwork) {
+		notifications <- network
+	})
+	err := irc.Connect()
+	if err != nil {
+		t.Fatalf("could not connect to fake bouncer: %v", err)
+	}
+	go irc.Loop()
+	defer irc.Quit()
+	assertEqual(bouncer.getBoundTo(), "1")
+	assertEqual(len(bouncer.getFailures()), 0)
+	bindIndex, capEndIndex := -1, -1
+	for i, line := range bouncer.getReceived() {
+		switch line {
+		case "BOUNCER BIND 1":
+			bindIndex = i
+		case "CAP END":
+			capEndIndex = i
+		}
+	}
+	assertEqual(bindIndex != -1 && bindIndex < capEndIndex, true)
+
+	networks, err := irc.ListBouncerNetworks()
+	assertEqual(err, nil)
+	assertEqual(len(networks), 2)
+	assertEqual(networks[0].ID, "1")
+	assertEqual(networks[0].Name(), "Libera")
+	assertEqual(networks[0].State(), "connected")
+	assertEqual(networks[1].Name(), "OFTC; the other one")
+
+	netid, err := irc.AddBouncerNetwork(map[string]string{"name": "Example", "host": "irc.example.com"})
+	assertEqual(err, nil)
+	assertEqual(netid, "3")
+	notification := <-notifications
+	assertEqual(notification.ID, "3")
+	assertEqual(notification.Attributes, map[string]string{"name": "Example", "host": "irc.example.com"})
+
+	assertEqual(irc.ChangeBouncerNetwork("3", map[string]string{"nickname": "bot2"}), nil)
+	notification = <-notifications
+	assertEqual(notification.Attributes, map[string]string{"nickname": "bot2"})
+
+	err = irc.ChangeBouncerNetwork("42", map[string]string{"nickname": "bot2"})
+	var reply StandardReply
+	assertEqual(errors.As(err, &reply), true)
+	assertEqual(reply.Code, "INVALID_NETID")
+
+	assertEqual(irc.DeleteBouncerNetwork("2"), nil)
+	notification = <-notifications
+	assertEqual(notification.ID, "2")
+	assertEqual(notification.Deleted, true)
+
+	networks, err = irc.ListBouncerNetworks()
+	assertEqual(err, nil)
+	assertEqual(len(networks), 2)
+	assertEqual(networks[1].ID, "3")
+	assertEqual(networks[1].Attributes["nickname"], "bot2")
+}
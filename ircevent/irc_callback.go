@@ -45,8 +45,9 @@ func (irc *Connection) addCallback(command string, callback Callback, prepend bo
 	}
 
 	if idNum == 0 {
-		idNum = irc.callbackCounter
+		// increment first: 0 means "assign a new ID number"
 		irc.callbackCounter++
+		idNum = irc.callbackCounter
 	}
 	id := CallbackID{command: command, id: idNum}
 	newPair := callbackPair{id: id.id, callback: callback}
@@ -73,8 +74,16 @@ func (irc *Connection) RemoveCallback(id CallbackID) {
 	case registrationEvent:
 		irc.removeCallbackNoMutex(RPL_ENDOFMOTD, id.id)
 		irc.removeCallbackNoMutex(ERR_NOMOTD, id.id)
+	case standardReplyEvent:
+		irc.removeCallbackNoMutex("FAIL", id.id)
+		irc.removeCallbackNoMutex("WARN", id.id)
+		irc.removeCallbackNoMutex("NOTE", id.id)
 	case "BATCH":
 		irc.removeBatchCallbackNoMutex(id.id)
+	case lagEvent:
+		irc.removeLagCallbackNoMutex(id.id)
+	case stateEvent:
+		irc.removeStateCallbackNoMutex(id.id)
 	default:
 		irc.removeCallbackNoMutex(id.command, id.id)
 	}
@@ -196,13 +205,14 @@ var (
 
 func (irc *Connection) handleBatchCommand(msg ircmsg.Message) {
 	if len(msg.Params) < 1 || len(msg.Params[0]) < 2 {
-		irc.Log.Printf("Invalid BATCH command from server\n")
+		irc.log(LogLevelWarn, "invalid BATCH command from server", nil, "Invalid BATCH command from server\n")
 		return
 	}
 
 	start := msg.Params[0][0] == '+'
 	if !start && msg.Params[0][0] != '-' {
-		irc.Log.Printf("Invalid BATCH ID from server: %s\n", msg.Params[0])
+		irc.log(LogLevelWarn, "invalid BATCH ID from server", []LogAttr{{"batch", msg.Params[0]}},
+			"Invalid BATCH ID from server: %s\n", msg.Params[0])
 		return
 	}
 	batchID := msg.Params[0][1:]
@@ -260,7 +270,8 @@ func (irc *Connection) handleBatchCommand(msg ircmsg.Message) {
 	}()
 
 	if err != nil {
-		irc.Log.Printf("batch error: %v (batchID=`%s`, parentBatchID=`%s`)", err, batchID, parentBatchID)
+		irc.log(LogLevelWarn, "batch error", []LogAttr{{"error", err}, {"batch", batchID}, {"parent_batch", parentBatchID}},
+			"batch error: %v (batchID=`%s`, parentBatchID=`%s`)", err, batchID, parentBatchID)
 	} else if callback != nil {
 		callback(finishedBatch)
 	} else if finishedBatch != nil {
@@ -318,7 +329,8 @@ func (irc *Connection) handleBatchedCommand(msg ircmsg.Message, batchID string)
 
 	bip := irc.batches[batchID]
 	if bip.batch == nil {
-		irc.Log.Printf("ignoring command with unknown batch ID %s\n", batchID)
+		irc.log(LogLevelWarn, "ignoring command with unknown batch ID", []LogAttr{{"command", msg.Command}, {"batch", batchID}},
+			"ignoring command with unknown batch ID %s\n", batchID)
 		return
 	}
 	bip.batch.Items = append(bip.batch.Items, &Batch{Message: msg})
@@ -349,7 +361,8 @@ func (irc *Connection) runCallbacks(msg ircmsg.Message) {
 				labelCallback = irc.getLabelCallback(label)
 			}
 			if labelCallback == nil {
-				irc.Log.Printf("received unrecognized label from server: %s\n", labelStr)
+				irc.log(LogLevelWarn, "received unrecognized label from server", []LogAttr{{"command", msg.Command}, {"label", labelStr}},
+					"received unrecognized label from server: %s\n", labelStr)
 				return
 			} else {
 				labelCallback(&Batch{
@@ -360,13 +373,29 @@ func (irc *Connection) runCallbacks(msg ircmsg.Message) {
 		}
 	}
 
+	// match echoes and errors against SendAndConfirm calls
+	if irc.handleEcho(msg) {
+		return
+	}
+
 	// OK, it's a normal IRC command
 	irc.HandleMessage(msg)
+
+	// after registration (and the connect callbacks, which may e.g. rejoin channels),
+	// send any messages left over from the previous connection:
+	if msg.Command == RPL_ENDOFMOTD || msg.Command == ERR_NOMOTD {
+		irc.resendUnsent()
+	}
 }
 
 func (irc *Connection) handleCallbackPanic() {
 	if r := recover(); r != nil {
-		irc.Log.Printf("Caught panic in callback: %v\n%s", r, debug.Stack())
+		stack := debug.Stack()
+		irc.log(LogLevelError, "caught panic in callback", []LogAttr{{"error", r}, {"stack", string(stack)}},
+			"Caught panic in callback: %v\n%s", r, stack)
+		if irc.Metrics != nil {
+			irc.Metrics.CallbackPanicked()
+		}
 	}
 }
 
@@ -383,8 +412,14 @@ func (irc *Connection) runDisconnectCallbacks() {
 
 // HandleMessage handles an IRC line using the available handlers. This can be
 // used in a batch or labeled-response callback to process an individual line.
+// If DedupeStore is set, lines whose msgid was already processed are ignored.
 func (irc *Connection) HandleMessage(event ircmsg.Message) {
+	if irc.isDuplicate(event) {
+		return
+	}
+
 	if irc.EnableCTCP {
+		irc.handleCTCPRequest(event)
 		eventRewriteCTCP(&event)
 	}
 
@@ -412,7 +447,7 @@ func (irc *Connection) setupCallbacks() {
 	irc.AddCallback("PING", func(e ircmsg.Message) { irc.Send("PONG", lastParam(&e)) })
 
 	// PONG: record time to make sure the server is responding to us
-	irc.AddCallback("PONG", func(e ircmsg.Message) { irc.recordPong(lastParam(&e)) })
+	irc.AddCallback("PONG", irc.recordPong)
 
 	// 433: ERR_NICKNAMEINUSE "<nick> :Nickname is already in use"
 	// 437: ERR_UNAVAILRESOURCE "<nick/channel> :Nick/channel is temporarily unavailable"
@@ -436,7 +471,9 @@ func (irc *Connection) setupCallbacks() {
 
 	irc.AddCallback("ERROR", func(e ircmsg.Message) {
 		if !irc.isQuitting() {
-			irc.Log.Printf("ERROR received from server: %s", strings.Join(e.Params, " "))
+			message := strings.Join(e.Params, " ")
+			irc.log(LogLevelError, "ERROR received from server", []LogAttr{{"error", message}},
+				"ERROR received from server: %s", message)
 		}
 	})
 
@@ -446,10 +483,6 @@ func (irc *Connection) setupCallbacks() {
 		irc.setupSASLCallbacks()
 	}
 
-	if irc.EnableCTCP {
-		irc.setupCTCPCallbacks()
-	}
-
 	// prepend our own callbacks for the end of registration,
 	// so they happen before any client-added callbacks
 	irc.addCallback(RPL_ENDOFMOTD, irc.handleRegistration, true, 0)
@@ -471,8 +504,12 @@ func (irc *Connection) handleRplWelcome(e ircmsg.Message) {
 }
 
 func (irc *Connection) handleRegistration(e ircmsg.Message) {
+	var newlyRegistered bool
 	// wake up Connect() if applicable
 	defer func() {
+		if newlyRegistered {
+			irc.setState(StateRegistered, nil)
+		}
 		select {
 		case irc.welcomeChan <- empty{}:
 		default:
@@ -486,6 +523,10 @@ func (irc *Connection) handleRegistration(e ircmsg.Message) {
 		return
 	}
 	irc.registered = true
+	newlyRegistered = true
+	if irc.sendQueue != nil {
+		irc.sendQueue.prioritize()
+	}
 
 	// mark the isupport complete
 	irc.isupport = irc.isupportPartial
@@ -533,23 +574,7 @@ func (irc *Connection) handleISupport(e ircmsg.Message) {
 }
 
 func unescapeISupportValue(in string) (out string) {
-	if strings.IndexByte(in, '\\') == -1 {
-		return in
-	}
-	var buf strings.Builder
-	for i := 0; i < len(in); {
-		if in[i] == '\\' && i+3 < len(in) && in[i+1] == 'x' {
-			hex := in[i+2 : i+4]
-			if octet, err := strconv.ParseInt(hex, 16, 8); err == nil {
-				buf.WriteByte(byte(octet))
-				i += 4
-				continue
-			}
-		}
-		buf.WriteByte(in[i])
-		i++
-	}
-	return buf.String()
+	return ircmsg.UnescapeISupportValue(in)
 }
 
 func (irc *Connection) handleCAP(e ircmsg.Message) {
@@ -655,10 +680,14 @@ func (irc *Connection) unregisterLabel(labelStr string) {
 // fails).
 func (irc *Connection) expireBatches(force bool) {
 	var failedCallbacks []LabelCallback
+	var failedEchoes []*pendingEcho
 	defer func() {
 		for _, bcb := range failedCallbacks {
 			bcb(nil)
 		}
+		for _, pending := range failedEchoes {
+			pending.result <- echoResult{err: NoEcho}
+		}
 	}()
 
 	irc.batchMutex.Lock()
@@ -672,11 +701,26 @@ func (irc *Connection) expireBatches(force bool) {
 		}
 	}
 
+	remainingEchoes := irc.pendingEchoes[:0]
+	for _, pending := range irc.pendingEchoes {
+		if force || now.Sub(pending.createdAt) > irc.KeepAlive {
+			failedEchoes = append(failedEchoes, pending)
+		} else {
+			remainingEchoes = append(remainingEchoes, pending)
+		}
+	}
+	irc.pendingEchoes = remainingEchoes
+
+	droppedBatches := 0
 	for batchID, bip := range irc.batches {
 		if now.Sub(bip.createdAt) > irc.KeepAlive {
 			delete(irc.batches, batchID)
+			droppedBatches++
 		}
 	}
+	if droppedBatches != 0 && irc.Metrics != nil {
+		irc.Metrics.BatchesDropped(droppedBatches)
+	}
 }
 
 func splitCAPToken(token string) (name, value string) {
@@ -693,7 +737,9 @@ func (irc *Connection) handleStandardReplies(e ircmsg.Message) {
 	// re. NOTE, if debug is enabled, we print the raw line anyway
 	switch e.Command {
 	case "FAIL", "WARN":
-		irc.Log.Printf("Received error code from server: %s %s\n", e.Command, strings.Join(e.Params, " "))
+		params := strings.Join(e.Params, " ")
+		irc.log(LogLevelWarn, "received error code from server", []LogAttr{{"command", e.Command}, {"params", params}},
+			"Received error code from server: %s %s\n", e.Command, params)
 	}
 }
 
@@ -1,13 +1,25 @@
 package ircevent
 
 import (
-	"fmt"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/ergochat/irc-go/ircmsg"
 )
 
+const (
+	// number of automatic CTCP replies that can be sent in a burst,
+	// before CTCPReplyInterval applies
+	ctcpReplyBurst = 4
+
+	defaultCTCPReplyInterval = time.Second
+)
+
+// CTCPHandler computes the reply to an incoming CTCP request. e is the
+// PRIVMSG that contained the request. If respond is false, no reply is sent.
+type CTCPHandler func(e ircmsg.Message, ctcp ircmsg.CTCP) (reply string, respond bool)
+
 func eventRewriteCTCP(event *ircmsg.Message) {
 	// XXX rewrite event.Command for CTCP
 	if !(event.Command == "PRIVMSG" && len(event.Params) == 2 && strings.HasPrefix(event.Params[1], "\x01")) {
@@ -45,24 +57,167 @@ func eventRewriteCTCP(event *ircmsg.Message) {
 	event.Params[len(event.Params)-1] = msg
 }
 
-func (irc *Connection) setupCTCPCallbacks() {
-	irc.AddCallback("CTCP_VERSION", func(e ircmsg.Message) {
-		irc.SendRaw(fmt.Sprintf("NOTICE %s :\x01VERSION %s\x01", e.Nick(), irc.Version))
-	})
+// AddCTCPHandler registers a handler for a CTCP request (e.g. "VERSION"),
+// replacing any existing handler for the same command. Handlers are only
+// invoked if EnableCTCP is set. By default, there are handlers for VERSION,
+// USERINFO, TIME, PING and CLIENTINFO (CLIENTINFO lists the commands for
+// which handlers are currently registered).
+func (irc *Connection) AddCTCPHandler(command string, handler CTCPHandler) {
+	command = strings.ToUpper(command)
+	if command == "" || handler == nil {
+		return
+	}
+
+	irc.eventsMutex.Lock()
+	defer irc.eventsMutex.Unlock()
+	irc.initCTCPHandlersNoMutex()
+	irc.ctcpHandlers[command] = handler
+}
+
+// RemoveCTCPHandler removes the handler for a CTCP request, including
+// the default handlers; no reply will be sent to that request.
+func (irc *Connection) RemoveCTCPHandler(command string) {
+	command = strings.ToUpper(command)
+
+	irc.eventsMutex.Lock()
+	defer irc.eventsMutex.Unlock()
+	irc.initCTCPHandlersNoMutex()
+	delete(irc.ctcpHandlers, command)
+}
+
+func (irc *Connection) initCTCPHandlersNoMutex() {
+	if irc.ctcpHandlers != nil {
+		return
+	}
+	irc.ctcpHandlers = map[string]CTCPHandler{
+		"VERSION": func(e ircmsg.Message, ctcp ircmsg.CTCP) (string, bool) {
+			return irc.Version, true
+		},
+		"USERINFO": func(e ircmsg.Message, ctcp ircmsg.CTCP) (string, bool) {
+			return irc.User, true
+		},
+		"TIME": func(e ircmsg.Message, ctcp ircmsg.CTCP) (string, bool) {
+			return time.Now().UTC().Format(time.RFC1123), true
+		},
+		"PING": func(e ircmsg.Message, ctcp ircmsg.CTCP) (string, bool) {
+			return ctcp.Argument, true
+		},
+		"CLIENTINFO": func(e ircmsg.Message, ctcp ircmsg.CTCP) (string, bool) {
+			return strings.Join(irc.ctcpCommands(), " "), true
+		},
+	}
+}
+
+func (irc *Connection) getCTCPHandler(command string) CTCPHandler {
+	irc.eventsMutex.Lock()
+	defer irc.eventsMutex.Unlock()
+	irc.initCTCPHandlersNoMutex()
+	return irc.ctcpHandlers[command]
+}
+
+// ctcpCommands returns the sorted list of CTCP commands we can reply to.
+func (irc *Connection) ctcpCommands() (result []string) {
+	irc.eventsMutex.Lock()
+	defer irc.eventsMutex.Unlock()
+	irc.initCTCPHandlersNoMutex()
+	result = make([]string, 0, len(irc.ctcpHandlers))
+	for command := range irc.ctcpHandlers {
+		result = append(result, command)
+	}
+	sort.Strings(result)
+	return
+}
+
+// allowCTCPReply implements rate limiting for CTCP replies, as a generic
+// cell rate algorithm (i.e., a leaky bucket with room for ctcpReplyBurst replies).
+func (irc *Connection) allowCTCPReply() bool {
+	interval := irc.CTCPReplyInterval
+	if interval <= 0 {
+		return true
+	}
+
+	irc.stateMutex.Lock()
+	defer irc.stateMutex.Unlock()
+	now := time.Now()
+	tat := irc.ctcpReplyTAT
+	if tat.Before(now) {
+		tat = now
+	}
+	if tat.Sub(now) > interval*(ctcpReplyBurst-1) {
+		return false
+	}
+	irc.ctcpReplyTAT = tat.Add(interval)
+	return true
+}
 
-	irc.AddCallback("CTCP_USERINFO", func(e ircmsg.Message) {
-		irc.SendRaw(fmt.Sprintf("NOTICE %s :\x01USERINFO %s\x01", e.Nick(), irc.User))
-	})
+// handleCTCPRequest sends an automatic reply to a CTCP request, if appropriate.
+func (irc *Connection) handleCTCPRequest(e ircmsg.Message) {
+	if e.Command != "PRIVMSG" {
+		return // never reply to a reply
+	}
+	ctcp, ok := ircmsg.ParseCTCP(&e)
+	if !ok || ctcp.Command == "ACTION" {
+		return
+	}
+	// don't reply to requests replayed from history, etc.:
+	if e.HasTag("batch") {
+		return
+	}
+	if irc.isChannel(e.Params[0]) && !irc.CTCPReplyToChannels {
+		return
+	}
+	nick := e.Nick()
+	if nick == "" || strings.IndexByte(nick, '.') != -1 || nick == irc.CurrentNick() {
+		// server-originated request, or an echo of our own request
+		return
+	}
+	handler := irc.getCTCPHandler(ctcp.Command)
+	if handler == nil {
+		return
+	}
+	if !irc.allowCTCPReply() {
+		irc.log(LogLevelDebug, "not replying to CTCP: rate limit exceeded", []LogAttr{{"ctcp", ctcp.Command}, {"source", nick}},
+			"Not replying to CTCP %s from %s: rate limit exceeded\n", ctcp.Command, nick)
+		return
+	}
+	if reply, respond := handler(e, ctcp); respond {
+		irc.SendCTCPReply(nick, ctcp.Command, reply)
+	}
+}
 
-	irc.AddCallback("CTCP_CLIENTINFO", func(e ircmsg.Message) {
-		irc.SendRaw(fmt.Sprintf("NOTICE %s :\x01CLIENTINFO PING VERSION TIME USERINFO CLIENTINFO\x01", e.Nick()))
-	})
+// encodeCTCP is like ircmsg.EncodeCTCP, but without low-level quoting,
+// which modern clients don't undo: the characters that would require it
+// (NUL, CR and LF) are rejected with ircmsg.ErrorLineContainsBadChar.
+func encodeCTCP(command, argument string) (result string, err error) {
+	if strings.ContainsAny(command, "\x00\r\n") || strings.ContainsAny(argument, "\x00\r\n") {
+		return "", ircmsg.ErrorLineContainsBadChar
+	}
+	if _, err = ircmsg.EncodeCTCP(command, argument); err != nil {
+		return
+	}
+	if argument != "" {
+		return "\x01" + command + " " + argument + "\x01", nil
+	}
+	return "\x01" + command + "\x01", nil
+}
 
-	irc.AddCallback("CTCP_TIME", func(e ircmsg.Message) {
-		irc.SendRaw(fmt.Sprintf("NOTICE %s :\x01TIME %s\x01", e.Nick(), time.Now().UTC().Format(time.RFC1123)))
-	})
+// SendCTCP sends a CTCP request (as a PRIVMSG) to a target. The command
+// and argument are sent as-is, without low-level quoting; if they contain
+// NUL, CR or LF, ircmsg.ErrorLineContainsBadChar is returned.
+func (irc *Connection) SendCTCP(target, command, argument string) error {
+	text, err := encodeCTCP(command, argument)
+	if err != nil {
+		return err
+	}
+	return irc.Send("PRIVMSG", target, text)
+}
 
-	irc.AddCallback("CTCP_PING", func(e ircmsg.Message) {
-		irc.SendRaw(fmt.Sprintf("NOTICE %s :\x01%s\x01", e.Nick(), e.Params[1]))
-	})
+// SendCTCPReply sends a CTCP reply (as a NOTICE) to a target, in the same
+// way as SendCTCP.
+func (irc *Connection) SendCTCPReply(target, command, argument string) error {
+	text, err := encodeCTCP(command, argument)
+	if err != nil {
+		return err
+	}
+	return irc.Send("NOTICE", target, text)
 }
@@ -0,0 +1,111 @@
+package ircevent
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+func TestCTCPReplies(t *testing.T) {
+	irc, sent := mockConnection()
+	irc.EnableCTCP = true
+	irc.User = "ircevent-user"
+
+	irc.HandleMessage(mustParse(":dan!d@localhost PRIVMSG go-eventirc :\x01VERSION\x01"))
+	assertEqual(nextSent(sent), "NOTICE dan :\x01VERSION ergochat/irc-go\x01")
+	irc.HandleMessage(mustParse(":dan!d@localhost PRIVMSG go-eventirc :\x01PING 1234 5678\x01"))
+	assertEqual(nextSent(sent), "NOTICE dan :\x01PING 1234 5678\x01")
+	irc.HandleMessage(mustParse(":dan!d@localhost PRIVMSG go-eventirc :\x01USERINFO\x01"))
+	assertEqual(nextSent(sent), "NOTICE dan :\x01USERINFO ircevent-user\x01")
+	irc.HandleMessage(mustParse(":dan!d@localhost PRIVMSG go-eventirc :\x01CLIENTINFO\x01"))
+	assertEqual(nextSent(sent), "NOTICE dan :\x01CLIENTINFO CLIENTINFO PING TIME USERINFO VERSION\x01")
+	irc.HandleMessage(mustParse(":dan!d@localhost PRIVMSG go-eventirc :\x01TIME\x01"))
+	if reply := nextSent(sent); !strings.HasPrefix(reply, "NOTICE dan :\x01TIME ") {
+		t.Errorf("unexpected TIME reply %q", reply)
+	}
+
+	// custom handlers, and CLIENTINFO reflects the registry:
+	irc.AddCTCPHandler("source", func(e ircmsg.Message, ctcp ircmsg.CTCP) (string, bool) {
+		return "https://github.com/ergochat/irc-go", true
+	})
+	irc.RemoveCTCPHandler("USERINFO")
+	irc.HandleMessage(mustParse(":dan!d@localhost PRIVMSG go-eventirc :\x01SOURCE\x01"))
+	assertEqual(nextSent(sent), "NOTICE dan :\x01SOURCE https://github.com/ergochat/irc-go\x01")
+	irc.HandleMessage(mustParse(":dan!d@localhost PRIVMSG go-eventirc :\x01USERINFO\x01"))
+	assertEqual(nextSent(sent), "")
+	irc.HandleMessage(mustParse(":dan!d@localhost PRIVMSG go-eventirc :\x01CLIENTINFO\x01"))
+	assertEqual(nextSent(sent), "NOTICE dan :\x01CLIENTINFO CLIENTINFO PING SOURCE TIME VERSION\x01")
+
+	// legacy rewritten callbacks still run:
+	actions := make(chan string, 1)
+	irc.AddCallback("CTCP_ACTION", func(e ircmsg.Message) { actions <- e.Params[1] })
+	irc.HandleMessage(mustParse(":dan!d@localhost PRIVMSG #chat :\x01ACTION waves\x01"))
+	assertEqual(<-actions, "waves")
+	assertEqual(nextSent(sent), "")
+}
+
+func TestCTCPNoReply(t *testing.T) {
+	irc, sent := mockConnection()
+	irc.EnableCTCP = true
+
+	// sent to a channel:
+	irc.HandleMessage(mustParse(":dan!d@localhost PRIVMSG #chat :\x01VERSION\x01"))
+	assertEqual(nextSent(sent), "")
+	// a reply, not a request:
+	irc.HandleMessage(mustParse(":dan!d@localhost NOTICE go-eventirc :\x01VERSION\x01"))
+	assertEqual(nextSent(sent), "")
+	// from a server:
+	irc.HandleMessage(mustParse(":irc.example.com PRIVMSG go-eventirc :\x01VERSION\x01"))
+	assertEqual(nextSent(sent), "")
+	// an echo of our own request:
+	irc.HandleMessage(mustParse(":go-eventirc!u@localhost PRIVMSG dan :\x01VERSION\x01"))
+	assertEqual(nextSent(sent), "")
+	// replayed from history:
+	irc.HandleMessage(mustParse("@batch=123 :dan!d@localhost PRIVMSG go-eventirc :\x01VERSION\x01"))
+	assertEqual(nextSent(sent), "")
+	// unknown command:
+	irc.HandleMessage(mustParse(":dan!d@localhost PRIVMSG go-eventirc :\x01FINGER\x01"))
+	assertEqual(nextSent(sent), "")
+
+	// channel replies go to the requester, if enabled:
+	irc.CTCPReplyToChannels = true
+	irc.HandleMessage(mustParse(":dan!d@localhost PRIVMSG #chat :\x01VERSION\x01"))
+	assertEqual(nextSent(sent), "NOTICE dan :\x01VERSION ergochat/irc-go\x01")
+
+	// CTCP is disabled entirely:
+	irc.EnableCTCP = false
+	irc.HandleMessage(mustParse(":dan!d@localhost PRIVMSG go-eventirc :\x01VERSION\x01"))
+	assertEqual(nextSent(sent), "")
+}
+
+func TestCTCPRateLimit(t *testing.T) {
+	irc, sent := mockConnection()
+	irc.EnableCTCP = true
+	irc.CTCPReplyInterval = time.Hour
+
+	for i := 0; i < ctcpReplyBurst+2; i++ {
+		irc.HandleMessage(mustParse(":dan!d@localhost PRIVMSG go-eventirc :\x01VERSION\x01"))
+	}
+	assertEqual(sent.len(), ctcpReplyBurst)
+}
+
+func TestSendCTCP(t *testing.T) {
+	irc, sent := mockConnection()
+	assertEqual(irc.Action("#chat", "waves"), nil)
+	assertEqual(nextSent(sent), "PRIVMSG #chat :\x01ACTION waves\x01")
+	assertEqual(irc.SendCTCP("dan", "VERSION", ""), nil)
+	assertEqual(nextSent(sent), "PRIVMSG dan \x01VERSION\x01")
+	assertEqual(irc.SendCTCP("dan", "VER\x01SION", ""), ircmsg.ErrorBadCTCP)
+	assertEqual(nextSent(sent), "")
+
+	// no low-level quoting: \x10 is sent as-is, and NUL, CR and LF are rejected
+	assertEqual(irc.Action("#chat", "a\x10b"), nil)
+	assertEqual(nextSent(sent), "PRIVMSG #chat :\x01ACTION a\x10b\x01")
+	for _, text := range []string{"a\rb", "a\nb", "a\x00b"} {
+		assertEqual(irc.Action("#chat", text), ircmsg.ErrorLineContainsBadChar)
+		assertEqual(irc.SendCTCPReply("dan", "PING", text), ircmsg.ErrorLineContainsBadChar)
+	}
+	assertEqual(nextSent(sent), "")
+}
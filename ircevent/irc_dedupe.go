@@ -0,0 +1,115 @@
+package ircevent
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+// MsgIDStore records the msgids of messages that have already been
+// processed, so that duplicates (e.g. messages seen live and then again
+// in a history fetch after reconnecting) can be dropped. Implementations
+// may persist the set (e.g. to disk) to deduplicate across restarts, and
+// must be safe for concurrent use.
+type MsgIDStore interface {
+	// Add records that the message with msgid (with server-time
+	// serverTime, or the time it was received if the server did not
+	// send one) has been processed. It returns false if msgid was
+	// already recorded.
+	Add(msgid string, serverTime time.Time) (added bool)
+}
+
+// MsgIDCache is an in-memory MsgIDStore, remembering at most Size
+// msgids, each for at most Window (if nonzero). It must be created
+// with NewMsgIDCache.
+type MsgIDCache struct {
+	size   int
+	window time.Duration
+
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	lru     list.List // of *msgIDEntry, least recently added at the front
+}
+
+type msgIDEntry struct {
+	msgid   string
+	addedAt time.Time
+}
+
+// NewMsgIDCache returns a MsgIDCache holding up to size msgids, each
+// for at most window (0 for no time limit).
+func NewMsgIDCache(size int, window time.Duration) *MsgIDCache {
+	cache := new(MsgIDCache)
+	cache.Initialize(size, window)
+	return cache
+}
+
+// Initialize initializes a MsgIDCache in place, discarding any contents.
+func (cache *MsgIDCache) Initialize(size int, window time.Duration) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.size = size
+	cache.window = window
+	cache.entries = make(map[string]*list.Element)
+	cache.lru.Init()
+}
+
+// Add implements MsgIDStore.
+func (cache *MsgIDCache) Add(msgid string, serverTime time.Time) (added bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	now := time.Now()
+	cache.expireNoMutex(now)
+	if _, ok := cache.entries[msgid]; ok {
+		return false
+	}
+	if cache.size <= 0 {
+		return true
+	}
+	for cache.lru.Len() >= cache.size {
+		cache.removeNoMutex(cache.lru.Front())
+	}
+	cache.entries[msgid] = cache.lru.PushBack(&msgIDEntry{msgid: msgid, addedAt: now})
+	return true
+}
+
+// Len returns the number of msgids currently remembered.
+func (cache *MsgIDCache) Len() int {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.expireNoMutex(time.Now())
+	return cache.lru.Len()
+}
+
+func (cache *MsgIDCache) expireNoMutex(now time.Time) {
+	if cache.window == 0 {
+		return
+	}
+	for {
+		front := cache.lru.Front()
+		if front == nil || now.Sub(front.Value.(*msgIDEntry).addedAt) <= cache.window {
+			return
+		}
+		cache.removeNoMutex(front)
+	}
+}
+
+func (cache *MsgIDCache) removeNoMutex(element *list.Element) {
+	delete(cache.entries, element.Value.(*msgIDEntry).msgid)
+	cache.lru.Remove(element)
+}
+
+// isDuplicate checks an event against DedupeStore, recording its msgid.
+func (irc *Connection) isDuplicate(event ircmsg.Message) bool {
+	if irc.DedupeStore == nil {
+		return false
+	}
+	present, msgid := event.GetTag("msgid")
+	if !present || msgid == "" {
+		return false
+	}
+	return !irc.DedupeStore.Add(msgid, irc.EventTime(event))
+}
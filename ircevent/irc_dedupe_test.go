@@ -0,0 +1,68 @@
+package ircevent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+func TestMsgIDCache(t *testing.T) {
+	cache := NewMsgIDCache(3, 0)
+	now := time.Now()
+	assertEqual(cache.Add("a", now), true)
+	assertEqual(cache.Add("a", now), false)
+	assertEqual(cache.Add("b", now), true)
+	assertEqual(cache.Add("c", now), true)
+	assertEqual(cache.Len(), 3)
+	// evicts "a":
+	assertEqual(cache.Add("d", now), true)
+	assertEqual(cache.Len(), 3)
+	assertEqual(cache.Add("b", now), false)
+	assertEqual(cache.Add("a", now), true)
+
+	cache = NewMsgIDCache(10, 10*time.Millisecond)
+	assertEqual(cache.Add("a", now), true)
+	assertEqual(cache.Add("a", now), false)
+	time.Sleep(20 * time.Millisecond)
+	assertEqual(cache.Len(), 0)
+	assertEqual(cache.Add("a", now), true)
+}
+
+type mapMsgIDStore map[string]time.Time
+
+func (store mapMsgIDStore) Add(msgid string, serverTime time.Time) bool {
+	if _, ok := store[msgid]; ok {
+		return false
+	}
+	store[msgid] = serverTime
+	return true
+}
+
+func TestDedupe(t *testing.T) {
+	irc, _ := mockConnection()
+	var texts []string
+	irc.AddCallback("PRIVMSG", func(e ircmsg.Message) { texts = append(texts, lastParam(&e)) })
+
+	// deduplication is opt-in:
+	irc.HandleMessage(mustParse("@msgid=1 :alice!u@h PRIVMSG #chan :one"))
+	irc.HandleMessage(mustParse("@msgid=1 :alice!u@h PRIVMSG #chan :one"))
+	assertEqual(texts, []string{"one", "one"})
+
+	store := make(mapMsgIDStore)
+	irc.DedupeStore = store
+	texts = nil
+	irc.HandleMessage(mustParse("@msgid=1;time=2021-06-01T12:00:00.000Z :alice!u@h PRIVMSG #chan :one"))
+	irc.HandleMessage(mustParse(":alice!u@h PRIVMSG #chan :no msgid"))
+	irc.HandleMessage(mustParse(":alice!u@h PRIVMSG #chan :no msgid"))
+	// a history batch overlapping with live messages:
+	irc.HandleBatch(&Batch{
+		Message: mustParse("BATCH +h chathistory #chan"),
+		Items: []*Batch{
+			{Message: mustParse("@batch=h;msgid=0 :alice!u@h PRIVMSG #chan :zero")},
+			{Message: mustParse("@batch=h;msgid=1 :alice!u@h PRIVMSG #chan :one")},
+		},
+	})
+	assertEqual(texts, []string{"one", "no msgid", "no msgid", "zero"})
+	assertEqual(store["1"], time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC))
+}
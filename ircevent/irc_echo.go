@@ -0,0 +1,236 @@
+package ircevent
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+var (
+	NoEcho      = errors.New("The server did not echo the message")
+	NotEchoable = errors.New("Only PRIVMSG, NOTICE and TAGMSG can be confirmed")
+)
+
+// SentMessage is the server's confirmation that it accepted a message
+// sent with SendAndConfirm.
+type SentMessage struct {
+	MsgID string    // msgid assigned by the server, if any
+	Time  time.Time // server-time of the message, or the zero Time
+	// Echo is the echo of the message, as relayed by the server
+	Echo ircmsg.Message
+}
+
+// NumericError is returned when the server rejects a command with an
+// error numeric, e.g. 404 ERR_CANNOTSENDTOCHAN.
+type NumericError struct {
+	ircmsg.Message
+}
+
+// Error implements error.
+func (e NumericError) Error() string {
+	params := e.Params
+	if len(params) != 0 {
+		params = params[1:] // skip our own nick
+	}
+	return e.Command + " " + strings.Join(params, " ")
+}
+
+type echoResult struct {
+	sent SentMessage
+	err  error
+}
+
+type pendingEcho struct {
+	createdAt time.Time
+	msg       ircmsg.Message
+	result    chan echoResult
+}
+
+func isEchoable(command string) bool {
+	switch command {
+	case "PRIVMSG", "NOTICE", "TAGMSG":
+		return true
+	default:
+		return false
+	}
+}
+
+func isErrorNumeric(command string) bool {
+	return len(command) == 3 && (command[0] == '4' || command[0] == '5') &&
+		'0' <= command[1] && command[1] <= '9' && '0' <= command[2] && command[2] <= '9'
+}
+
+// SendAndConfirm sends a PRIVMSG, NOTICE or TAGMSG, then synchronously waits
+// for the server to echo it, returning the msgid and server-time the server
+// assigned. It requires the echo-message capability; if labeled-response was
+// also negotiated, it is used to correlate the echo, otherwise the echo is
+// identified by its contents. If the server rejects the message, the error is
+// a StandardReply (for FAIL) or a NumericError (e.g. for 404).
+//
+// If the server neither echoes nor rejects the message, SendAndConfirm
+// blocks until the confirmation expires after KeepAlive (4 minutes by
+// default), then returns NoLabeledResponse (with labeled-response) or
+// NoEcho; if the connection is lost first, it returns then. To wait for
+// less time, use SendAndConfirmContext.
+func (irc *Connection) SendAndConfirm(tags map[string]string, command string, params ...string) (result SentMessage, err error) {
+	return irc.SendAndConfirmContext(context.Background(), tags, command, params...)
+}
+
+// SendAndConfirmContext is like SendAndConfirm, but stops waiting when ctx
+// is done, returning ctx.Err(). In that case the message may still have
+// been (or be) accepted by the server.
+func (irc *Connection) SendAndConfirmContext(ctx context.Context, tags map[string]string, command string, params ...string) (result SentMessage, err error) {
+	command = strings.ToUpper(command)
+	if !isEchoable(command) || len(params) == 0 {
+		return result, NotEchoable
+	}
+	if !irc.echoNegotiated() {
+		return result, CapabilityNotNegotiated
+	}
+
+	done := make(chan echoResult, 1)
+	if irc.labelNegotiated() {
+		err = irc.SendWithLabel(func(batch *Batch) {
+			done <- irc.processLabeledEcho(batch, command)
+		}, tags, command, params...)
+		if err != nil {
+			return
+		}
+	} else {
+		pending := &pendingEcho{
+			createdAt: time.Now(),
+			msg:       ircmsg.MakeMessage(tags, "", command, params...),
+			result:    done,
+		}
+		irc.batchMutex.Lock()
+		irc.pendingEchoes = append(irc.pendingEchoes, pending)
+		irc.batchMutex.Unlock()
+		err = irc.SendIRCMessage(pending.msg)
+		if err != nil {
+			irc.removePendingEcho(pending)
+			return
+		}
+	}
+	select {
+	case r := <-done:
+		return r.sent, r.err
+	case <-ctx.Done():
+		// the pending confirmation is left in place (done is buffered), so
+		// that the echo, if it arrives, isn't matched to another message
+		return result, ctx.Err()
+	}
+}
+
+func makeSentMessage(echo ircmsg.Message) (sent SentMessage) {
+	sent.Echo = echo
+	_, sent.MsgID = echo.GetTag("msgid")
+	sent.Time, _ = echo.ServerTime()
+	return
+}
+
+// findInBatch returns the first message in a (possibly nested) batch
+// satisfying a predicate.
+func findInBatch(batch *Batch, pred func(*ircmsg.Message) bool) *ircmsg.Message {
+	if batch.Command != "BATCH" && pred(&batch.Message) {
+		return &batch.Message
+	}
+	for _, item := range batch.Items {
+		if found := findInBatch(item, pred); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func (irc *Connection) processLabeledEcho(batch *Batch, command string) (result echoResult) {
+	if batch == nil {
+		result.err = NoLabeledResponse
+		return
+	}
+	if fail, ok := findFail(batch); ok {
+		result.err = fail
+		return
+	}
+	if numeric := findInBatch(batch, func(msg *ircmsg.Message) bool { return isErrorNumeric(msg.Command) }); numeric != nil {
+		result.err = NumericError{Message: *numeric}
+		return
+	}
+	echo := findInBatch(batch, func(msg *ircmsg.Message) bool { return msg.Command == command })
+	if echo == nil {
+		result.err = NoEcho
+		return
+	}
+	result.sent = makeSentMessage(*echo)
+	// a labeled echo bypasses the normal event handlers; run them now
+	// (on the read loop goroutine, as usual) unless echoes are suppressed
+	if !irc.SuppressEchoes {
+		irc.HandleMessage(*echo)
+	}
+	return
+}
+
+func (irc *Connection) removePendingEcho(pending *pendingEcho) {
+	irc.batchMutex.Lock()
+	defer irc.batchMutex.Unlock()
+	for i, p := range irc.pendingEchoes {
+		if p == pending {
+			irc.pendingEchoes = append(irc.pendingEchoes[:i], irc.pendingEchoes[i+1:]...)
+			return
+		}
+	}
+}
+
+// popPendingEcho removes and returns the oldest pending echo that
+// satisfies a predicate.
+func (irc *Connection) popPendingEcho(pred func(*ircmsg.Message) bool) *pendingEcho {
+	irc.batchMutex.Lock()
+	defer irc.batchMutex.Unlock()
+	for i, p := range irc.pendingEchoes {
+		if pred(&p.msg) {
+			irc.pendingEchoes = append(irc.pendingEchoes[:i], irc.pendingEchoes[i+1:]...)
+			return p
+		}
+	}
+	return nil
+}
+
+// handleEcho matches an unlabeled message from the server against the
+// pending confirmations; it returns whether the message is an echo
+// that should not be passed to the normal event handlers.
+func (irc *Connection) handleEcho(msg ircmsg.Message) (suppress bool) {
+	if !irc.echoNegotiated() {
+		return false
+	}
+	switch {
+	case isEchoable(msg.Command) && len(msg.Params) != 0 && msg.Nick() == irc.CurrentNick():
+		pending := irc.popPendingEcho(func(sent *ircmsg.Message) bool {
+			return sent.Command == msg.Command && len(sent.Params) == len(msg.Params) &&
+				strings.EqualFold(sent.Params[0], msg.Params[0]) && lastParam(sent) == lastParam(&msg)
+		})
+		if pending != nil {
+			pending.result <- echoResult{sent: makeSentMessage(msg)}
+		}
+		return irc.SuppressEchoes
+	case isErrorNumeric(msg.Command) && len(msg.Params) > 1:
+		// e.g. 404 ERR_CANNOTSENDTOCHAN <nick> <channel> :<reason>
+		pending := irc.popPendingEcho(func(sent *ircmsg.Message) bool {
+			return strings.EqualFold(sent.Params[0], msg.Params[1])
+		})
+		if pending != nil {
+			pending.result <- echoResult{err: NumericError{Message: msg}}
+		}
+	case msg.Command == "FAIL":
+		if reply, ok := ParseStandardReply(msg); ok {
+			pending := irc.popPendingEcho(func(sent *ircmsg.Message) bool {
+				return sent.Command == reply.Command
+			})
+			if pending != nil {
+				pending.result <- echoResult{err: reply}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package ircevent
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+func mockEchoConnection(capFlags uint32) (irc *Connection, sent *sendQueue) {
+	irc, sent = mockConnection()
+	irc.capFlags = capFlagEchoMessage | capFlags
+	irc.batches = make(map[string]batchInProgress)
+	irc.labelCallbacks = make(map[int64]pendingLabel)
+	irc.KeepAlive = time.Minute
+	return
+}
+
+func sendAndConfirmAsync(irc *Connection, command string, params ...string) chan echoResult {
+	results := make(chan echoResult, 1)
+	go func() {
+		sent, err := irc.SendAndConfirm(nil, command, params...)
+		results <- echoResult{sent, err}
+	}()
+	return results
+}
+
+func TestSendAndConfirmCaps(t *testing.T) {
+	irc, _ := mockConnection()
+	_, err := irc.SendAndConfirm(nil, "PRIVMSG", "#chan", "hi")
+	assertEqual(err, CapabilityNotNegotiated)
+	_, err = irc.SendAndConfirm(nil, "JOIN", "#chan")
+	assertEqual(err, NotEchoable)
+}
+
+func TestSendAndConfirmLabeled(t *testing.T) {
+	irc, sent := mockEchoConnection(capFlagBatch | capFlagLabeledResponse)
+	var privmsgs []ircmsg.Message
+	irc.AddCallback("PRIVMSG", func(e ircmsg.Message) { privmsgs = append(privmsgs, e) })
+
+	results := sendAndConfirmAsync(irc, "PRIVMSG", "#chan", "hi")
+	msg := mustParse(string(waitSent(sent)))
+	_, label := msg.GetTag("label")
+	irc.runCallbacks(mustParse("@label=" + label + ";msgid=abc;time=2021-06-01T12:00:00.123Z :go-eventirc!u@h PRIVMSG #chan :hi"))
+	r := <-results
+	assertEqual(r.err, nil)
+	assertEqual(r.sent.MsgID, "abc")
+	assertEqual(r.sent.Time, time.Date(2021, 6, 1, 12, 0, 0, 123000000, time.UTC))
+	assertEqual(r.sent.Echo.Params, []string{"#chan", "hi"})
+	assertEqual(len(privmsgs), 1)
+
+	irc.SuppressEchoes = true
+	results = sendAndConfirmAsync(irc, "PRIVMSG", "#chan", "hi again")
+	msg = mustParse(string(waitSent(sent)))
+	_, label = msg.GetTag("label")
+	irc.runCallbacks(mustParse("@label=" + label + ";msgid=def :go-eventirc!u@h PRIVMSG #chan :hi again"))
+	r = <-results
+	assertEqual(r.sent.MsgID, "def")
+	assertEqual(len(privmsgs), 1)
+
+	results = sendAndConfirmAsync(irc, "PRIVMSG", "#secret", "hi")
+	msg = mustParse(string(waitSent(sent)))
+	_, label = msg.GetTag("label")
+	irc.runCallbacks(mustParse("@label=" + label + " :irc.example.com 404 go-eventirc #secret :Cannot send to channel"))
+	r = <-results
+	var numericErr NumericError
+	assertEqual(errors.As(r.err, &numericErr), true)
+	assertEqual(numericErr.Command, ERR_CANNOTSENDTOCHAN)
+	assertEqual(r.err.Error(), "404 #secret Cannot send to channel")
+
+	results = sendAndConfirmAsync(irc, "PRIVMSG", "#chan", "spam")
+	msg = mustParse(string(waitSent(sent)))
+	_, label = msg.GetTag("label")
+	irc.runCallbacks(mustParse("@label=" + label + " :irc.example.com FAIL PRIVMSG SPAM #chan :Message rejected"))
+	r = <-results
+	var reply StandardReply
+	assertEqual(errors.As(r.err, &reply), true)
+	assertEqual(reply.Code, "SPAM")
+}
+
+func TestSendAndConfirmUnlabeled(t *testing.T) {
+	irc, sent := mockEchoConnection(0)
+	var privmsgs []ircmsg.Message
+	irc.AddCallback("PRIVMSG", func(e ircmsg.Message) { privmsgs = append(privmsgs, e) })
+	var numerics int
+	irc.AddCallback(ERR_CANNOTSENDTOCHAN, func(e ircmsg.Message) { numerics++ })
+
+	results := sendAndConfirmAsync(irc, "PRIVMSG", "#chan", "one")
+	assertEqual(mustParse(string(waitSent(sent))).Params, []string{"#chan", "one"})
+	// messages from others, and unrelated echoes, are not confirmations:
+	irc.runCallbacks(mustParse("@msgid=x :alice!u@h PRIVMSG #chan :one"))
+	irc.runCallbacks(mustParse("@msgid=y :go-eventirc!u@h PRIVMSG #chan :two"))
+	irc.runCallbacks(mustParse("@msgid=z :go-eventirc!u@h PRIVMSG #Chan :one"))
+	r := <-results
+	assertEqual(r.err, nil)
+	assertEqual(r.sent.MsgID, "z")
+	assertEqual(len(privmsgs), 3)
+
+	results = sendAndConfirmAsync(irc, "PRIVMSG", "#secret", "hi")
+	waitSent(sent)
+	irc.runCallbacks(mustParse(":irc.example.com 404 go-eventirc #secret :Cannot send to channel"))
+	r = <-results
+	_, ok := r.err.(NumericError)
+	assertEqual(ok, true)
+	assertEqual(numerics, 1)
+
+	irc.SuppressEchoes = true
+	irc.runCallbacks(mustParse(":go-eventirc!u@h PRIVMSG #chan :unconfirmed"))
+	assertEqual(len(privmsgs), 3)
+
+	results = sendAndConfirmAsync(irc, "NOTICE", "#chan", "lost")
+	waitSent(sent)
+	irc.expireBatches(true)
+	r = <-results
+	assertEqual(r.err, NoEcho)
+}
+
+func TestSendAndConfirmContext(t *testing.T) {
+	irc, sent := mockEchoConnection(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	results := make(chan echoResult, 1)
+	go func() {
+		sent, err := irc.SendAndConfirmContext(ctx, nil, "PRIVMSG", "#chan", "hi")
+		results <- echoResult{sent, err}
+	}()
+	waitSent(sent)
+	cancel()
+	r := <-results
+	assertEqual(r.err, context.Canceled)
+
+	// the late echo is still consumed by the abandoned confirmation:
+	irc.SuppressEchoes = true
+	var privmsgs int
+	irc.AddCallback("PRIVMSG", func(e ircmsg.Message) { privmsgs++ })
+	irc.runCallbacks(mustParse("@msgid=x :go-eventirc!u@h PRIVMSG #chan :hi"))
+	assertEqual(privmsgs, 0)
+	irc.batchMutex.Lock()
+	assertEqual(len(irc.pendingEchoes), 0)
+	irc.batchMutex.Unlock()
+}
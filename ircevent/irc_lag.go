@@ -0,0 +1,142 @@
+package ircevent
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// fake event for managing lag callbacks
+	lagEvent = "\x00LAG"
+)
+
+// LagCallback is run when the lag to the server crosses LagThreshold:
+// exceeded is true if it rose above the threshold, false if it recovered.
+type LagCallback func(lag time.Duration, exceeded bool)
+
+type lagCallbackPair struct {
+	id       uint64
+	callback LagCallback
+}
+
+// LagSample is a single measurement of the round-trip time to the server.
+type LagSample struct {
+	Time time.Time     // when the measurement completed
+	Lag  time.Duration // round-trip time of PING and PONG
+}
+
+func keepaliveParam(t time.Time) string {
+	return fmt.Sprintf("%s%d", keepalivePrefix, t.UnixNano())
+}
+
+// Lag returns the most recently measured round-trip time to the server,
+// as measured by keepalive PINGs (or MeasureLag). ok is false if there is
+// no measurement yet.
+func (irc *Connection) Lag() (lag time.Duration, ok bool) {
+	irc.stateMutex.Lock()
+	defer irc.stateMutex.Unlock()
+	if len(irc.keepaliveSamples) == 0 {
+		return
+	}
+	return irc.keepaliveSamples[len(irc.keepaliveSamples)-1].rtt, true
+}
+
+// LagHistory returns the recent lag measurements, oldest first.
+func (irc *Connection) LagHistory() (result []LagSample) {
+	irc.stateMutex.Lock()
+	defer irc.stateMutex.Unlock()
+	result = make([]LagSample, len(irc.keepaliveSamples))
+	for i, sample := range irc.keepaliveSamples {
+		result[i] = LagSample{Time: sample.receivedAt, Lag: sample.rtt}
+	}
+	return
+}
+
+// MeasureLag sends a PING immediately and waits (for up to Timeout)
+// for the server's PONG, returning the round-trip time. The measurement
+// is also recorded in the lag history.
+func (irc *Connection) MeasureLag() (lag time.Duration, err error) {
+	param := keepaliveParam(time.Now())
+	result := make(chan time.Duration, 1)
+	irc.stateMutex.Lock()
+	if irc.pingWaiters == nil {
+		irc.pingWaiters = make(map[string]chan time.Duration)
+	}
+	irc.pingWaiters[param] = result
+	timeout, end := irc.Timeout, irc.end
+	irc.stateMutex.Unlock()
+
+	defer func() {
+		irc.stateMutex.Lock()
+		delete(irc.pingWaiters, param)
+		irc.stateMutex.Unlock()
+	}()
+
+	if err = irc.Send("PING", param); err != nil {
+		return
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case lag = <-result:
+	case <-timer.C:
+		err = ServerTimedOut
+	case <-end:
+		err = ClientDisconnected
+	}
+	return
+}
+
+// AddLagCallback adds a callback that is run when the lag to the server
+// crosses LagThreshold, in either direction. The lag is measured when a
+// keepalive PONG is received; an unanswered PING is also checked against
+// the threshold on the next keepalive tick (every Timeout). The callback
+// can be removed as usual with RemoveCallback.
+func (irc *Connection) AddLagCallback(callback LagCallback) CallbackID {
+	irc.eventsMutex.Lock()
+	defer irc.eventsMutex.Unlock()
+
+	irc.callbackCounter++
+	idNum := irc.callbackCounter
+	newList := make([]lagCallbackPair, len(irc.lagCallbacks)+1)
+	copy(newList, irc.lagCallbacks)
+	newList[len(newList)-1] = lagCallbackPair{id: idNum, callback: callback}
+	irc.lagCallbacks = newList
+	return CallbackID{command: lagEvent, id: idNum}
+}
+
+func (irc *Connection) removeLagCallbackNoMutex(idNum uint64) {
+	newList := make([]lagCallbackPair, 0, len(irc.lagCallbacks))
+	for _, p := range irc.lagCallbacks {
+		if p.id != idNum {
+			newList = append(newList, p)
+		}
+	}
+	irc.lagCallbacks = newList
+}
+
+// checkLagThresholdNoMutex records a completed lag measurement, returning
+// whether it crossed LagThreshold; call with stateMutex held.
+func (irc *Connection) checkLagThresholdNoMutex(lag time.Duration) (crossed bool) {
+	if irc.LagThreshold <= 0 {
+		return false
+	}
+	exceeded := lag > irc.LagThreshold
+	crossed = exceeded != irc.lagExceeded
+	irc.lagExceeded = exceeded
+	return
+}
+
+func (irc *Connection) runLagCallbacks(lag time.Duration, exceeded bool) {
+	if !irc.AllowPanic {
+		defer irc.handleCallbackPanic()
+	}
+
+	irc.eventsMutex.Lock()
+	callbacks := irc.lagCallbacks
+	irc.eventsMutex.Unlock()
+
+	for _, pair := range callbacks {
+		pair.callback(lag, exceeded)
+	}
+}
@@ -0,0 +1,108 @@
+package ircevent
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type lagEventForTesting struct {
+	lag      time.Duration
+	exceeded bool
+}
+
+func TestLag(t *testing.T) {
+	irc, _ := mockConnection()
+	_, ok := irc.Lag()
+	assertEqual(ok, false)
+	assertEqual(len(irc.LagHistory()), 0)
+
+	irc.LagThreshold = time.Second
+	var events []lagEventForTesting
+	id := irc.AddLagCallback(func(lag time.Duration, exceeded bool) {
+		events = append(events, lagEventForTesting{lag, exceeded})
+	})
+
+	irc.recordPong(mockPong(time.Now().Add(-100*time.Millisecond), time.Time{}))
+	lag, ok := irc.Lag()
+	assertEqual(ok, true)
+	assertDurationNear(lag, 100*time.Millisecond, 20*time.Millisecond)
+	assertEqual(len(events), 0)
+
+	irc.recordPong(mockPong(time.Now().Add(-2*time.Second), time.Time{}))
+	irc.recordPong(mockPong(time.Now().Add(-3*time.Second), time.Time{}))
+	irc.recordPong(mockPong(time.Now().Add(-200*time.Millisecond), time.Time{}))
+	assertEqual(len(events), 2)
+	assertEqual(events[0].exceeded, true)
+	assertDurationNear(events[0].lag, 2*time.Second, 20*time.Millisecond)
+	assertEqual(events[1].exceeded, false)
+
+	history := irc.LagHistory()
+	assertEqual(len(history), 4)
+	assertDurationNear(history[1].Lag, 2*time.Second, 20*time.Millisecond)
+	assertEqual(history[0].Time.Before(history[3].Time), true)
+
+	irc.RemoveCallback(id)
+	irc.recordPong(mockPong(time.Now().Add(-2*time.Second), time.Time{}))
+	assertEqual(len(events), 2)
+}
+
+func TestMissedPongs(t *testing.T) {
+	irc, sent := mockConnection()
+	irc.Timeout = time.Minute
+	irc.KeepAlive = time.Minute
+	irc.MaxMissedPongs = 2
+	irc.LagThreshold = 30 * time.Second
+	var events []lagEventForTesting
+	irc.AddLagCallback(func(lag time.Duration, exceeded bool) {
+		events = append(events, lagEventForTesting{lag, exceeded})
+	})
+
+	irc.processTick(1)
+	assertEqual(strings.HasPrefix(nextSent(sent), "PING "+keepalivePrefix), true)
+	// simulate the passage of time:
+	irc.pingSentAt = time.Now().Add(-time.Minute)
+	irc.processTick(2)
+	// tolerated; the PING is retried, and the threshold is crossed:
+	assertEqual(irc.getError(), nil)
+	assertEqual(strings.HasPrefix(nextSent(sent), "PING "+keepalivePrefix), true)
+	assertEqual(len(events), 1)
+	assertEqual(events[0].exceeded, true)
+
+	// a PONG resets the count:
+	irc.recordPong(mockPong(time.Now().Add(-time.Second), time.Time{}))
+	assertEqual(irc.missedPongs, 0)
+	assertEqual(len(events), 2)
+	assertEqual(events[1].exceeded, false)
+
+	irc.processTick(3)
+	irc.processTick(4)
+	assertEqual(irc.getError(), nil)
+	irc.processTick(5)
+	assertEqual(irc.getError(), ServerTimedOut)
+}
+
+func TestMeasureLag(t *testing.T) {
+	irc, sent := mockConnection()
+	irc.Timeout = time.Minute
+
+	type result struct {
+		lag time.Duration
+		err error
+	}
+	results := make(chan result, 1)
+	go func() {
+		lag, err := irc.MeasureLag()
+		results <- result{lag, err}
+	}()
+	ping := mustParse(string(waitSent(sent)))
+	assertEqual(ping.Command, "PING")
+	time.Sleep(10 * time.Millisecond)
+	irc.recordPong(mustParse(":irc.example.com PONG irc.example.com " + ping.Params[0]))
+	r := <-results
+	assertEqual(r.err, nil)
+	assertDurationNear(r.lag, 10*time.Millisecond, 50*time.Millisecond)
+	lag, _ := irc.Lag()
+	assertEqual(lag, r.lag)
+	assertEqual(len(irc.pingWaiters), 0)
+}
@@ -0,0 +1,81 @@
+package ircevent
+
+import (
+	"bytes"
+)
+
+// LogLevel is the severity of a logged event; the values are the same
+// as those of log/slog's levels.
+type LogLevel int
+
+const (
+	LogLevelDebug LogLevel = -4 // raw traffic and other details; see Debug
+	LogLevelInfo  LogLevel = 0
+	LogLevelWarn  LogLevel = 4 // problems with the server's messages, or errors it reports
+	LogLevelError LogLevel = 8 // disconnections and panics in callbacks
+)
+
+// LogAttr is a key-value attribute of a logged event.
+type LogAttr struct {
+	Key   string
+	Value interface{}
+}
+
+// StructuredLogger receives the events logged by a Connection, with
+// attributes such as "server", "nick", "direction" (of a line: "in" or
+// "out"), "command", "line", "batch", "label" and "error". See
+// NewSlogLogger for an implementation backed by a log/slog Handler.
+type StructuredLogger interface {
+	// Enabled reports whether events of the given level should be logged.
+	Enabled(level LogLevel) bool
+	Log(level LogLevel, msg string, attrs ...LogAttr)
+}
+
+// log records an event. If Logger is set, it receives msg and attrs (plus
+// the server and nick); otherwise format and args are printed to Log,
+// in which case events at LogLevelDebug are only printed if Debug is set.
+func (irc *Connection) log(level LogLevel, msg string, attrs []LogAttr, format string, args ...interface{}) {
+	if irc.Logger != nil {
+		if !irc.Logger.Enabled(level) {
+			return
+		}
+		fullAttrs := make([]LogAttr, 0, len(attrs)+2)
+		fullAttrs = append(fullAttrs, LogAttr{"server", irc.Server}, LogAttr{"nick", irc.Nick})
+		irc.Logger.Log(level, msg, append(fullAttrs, attrs...)...)
+		return
+	}
+	if level <= LogLevelDebug && !irc.Debug {
+		return
+	}
+	irc.Log.Printf(format, args...)
+}
+
+// logLine logs a raw line sent to or received from the server.
+func (irc *Connection) logLine(direction string, line []byte) {
+	if irc.Logger == nil && !irc.Debug {
+		return
+	}
+	line = bytes.TrimSpace(line)
+	arrow := "<--"
+	if direction == "out" {
+		arrow = "-->"
+	}
+	irc.log(LogLevelDebug, "line", []LogAttr{{"direction", direction}, {"command", lineCommand(line)}, {"line", string(line)}},
+		"%s %s\n", arrow, line)
+}
+
+// lineCommand returns the command of a raw IRC line, skipping any tags
+// and source, without fully parsing it.
+func lineCommand(line []byte) string {
+	for len(line) != 0 && (line[0] == '@' || line[0] == ':') {
+		i := bytes.IndexByte(line, ' ')
+		if i == -1 {
+			return ""
+		}
+		line = bytes.TrimLeft(line[i:], " ")
+	}
+	if i := bytes.IndexByte(line, ' '); i != -1 {
+		line = line[:i]
+	}
+	return string(line)
+}
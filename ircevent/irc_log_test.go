@@ -0,0 +1,69 @@
+package ircevent
+
+import (
+	"bytes"
+	"log"
+	"testing"
+)
+
+type loggedEvent struct {
+	level LogLevel
+	msg   string
+	attrs []LogAttr
+}
+
+type recordingLogger struct {
+	minLevel LogLevel
+	events   []loggedEvent
+}
+
+func (l *recordingLogger) Enabled(level LogLevel) bool {
+	return level >= l.minLevel
+}
+
+func (l *recordingLogger) Log(level LogLevel, msg string, attrs ...LogAttr) {
+	l.events = append(l.events, loggedEvent{level, msg, attrs})
+}
+
+func TestLineCommand(t *testing.T) {
+	assertEqual(lineCommand([]byte("PING 123")), "PING")
+	assertEqual(lineCommand([]byte(":irc.example.com 001 bot :Welcome")), "001")
+	assertEqual(lineCommand([]byte("@time=2023-01-01T00:00:00.000Z :nick!u@h  PRIVMSG #ch :hi")), "PRIVMSG")
+	assertEqual(lineCommand([]byte("QUIT")), "QUIT")
+	assertEqual(lineCommand([]byte(":source")), "")
+}
+
+func TestLogFallback(t *testing.T) {
+	var buf bytes.Buffer
+	irc := &Connection{Log: log.New(&buf, "", 0)}
+	irc.logLine("out", []byte("PING 123\r\n"))
+	assertEqual(buf.String(), "")
+	irc.log(LogLevelWarn, "batch error", []LogAttr{{"batch", "b"}}, "batch error: %s\n", "b")
+	assertEqual(buf.String(), "batch error: b\n")
+
+	buf.Reset()
+	irc.Debug = true
+	irc.logLine("out", []byte("PING 123\r\n"))
+	assertEqual(buf.String(), "--> PING 123\n")
+}
+
+func TestStructuredLogger(t *testing.T) {
+	logger := &recordingLogger{minLevel: LogLevelInfo}
+	irc := &Connection{Server: "irc.example.com:6697", Nick: "bot", Logger: logger}
+	irc.logLine("in", []byte(":irc.example.com PONG irc.example.com 123\r\n"))
+	assertEqual(len(logger.events), 0)
+
+	logger.minLevel = LogLevelDebug
+	irc.logLine("in", []byte(":irc.example.com PONG irc.example.com 123\r\n"))
+	irc.handleBatchedCommand(mustParse("@batch=xyz PRIVMSG #ch :hi"), "xyz")
+	assertEqual(logger.events, []loggedEvent{
+		{LogLevelDebug, "line", []LogAttr{
+			{"server", "irc.example.com:6697"}, {"nick", "bot"},
+			{"direction", "in"}, {"command", "PONG"}, {"line", ":irc.example.com PONG irc.example.com 123"},
+		}},
+		{LogLevelWarn, "ignoring command with unknown batch ID", []LogAttr{
+			{"server", "irc.example.com:6697"}, {"nick", "bot"},
+			{"command", "PRIVMSG"}, {"batch", "xyz"},
+		}},
+	})
+}
@@ -0,0 +1,333 @@
+package ircevent
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+var (
+	NetworkExists   = errors.New("A network with this name is already being managed")
+	NoSuchNetwork   = errors.New("No network with this name is being managed")
+	ManagerShutDown = errors.New("The manager has been shut down")
+)
+
+// NetworkCallback handles an event from one of a Manager's connections;
+// network is the name under which the connection was added.
+type NetworkCallback func(network string, irc *Connection, e ircmsg.Message)
+
+// ManagerCallbackID identifies a callback added to a Manager.
+type ManagerCallbackID struct {
+	id uint64
+}
+
+type managerCallbackKind uint
+
+const (
+	managerCallbackCommand managerCallbackKind = iota
+	managerCallbackConnect
+	managerCallbackDisconnect
+)
+
+type managerCallback struct {
+	id       uint64
+	kind     managerCallbackKind
+	command  string
+	callback NetworkCallback
+}
+
+type managedNetwork struct {
+	name      string
+	irc       *Connection
+	stop      chan empty // closing this interrupts waits between connection attempts
+	done      chan empty // closed when the network's goroutine exits
+	lastError error      // protected by the Manager's mutex
+	callbacks map[uint64]CallbackID
+	// the callback that records lastError:
+	lastErrorCallback CallbackID
+}
+
+// NetworkStatus describes the state of one of a Manager's connections.
+type NetworkStatus struct {
+	Name      string
+	Connected bool
+	Nick      string        // current nickname, if connected
+	Lag       time.Duration // most recent keepalive round-trip time, or 0
+	LastError error         // the most recent connection error, if any
+}
+
+// Manager runs a set of Connections (e.g. to different networks), each
+// identified by a name. It connects them, runs their Loops, and dispatches
+// their events to shared callbacks. The zero value is ready to use.
+type Manager struct {
+	mutex     sync.Mutex
+	networks  map[string]*managedNetwork
+	callbacks []managerCallback
+	counter   uint64
+	shutDown  bool
+}
+
+// Add starts managing a Connection under the given name: it is connected
+// (retrying every ReconnectFreq until the first attempt succeeds) and its
+// Loop is run, in a new goroutine. The Connection must not have been
+// connected already.
+func (m *Manager) Add(name string, irc *Connection) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.shutDown {
+		return ManagerShutDown
+	}
+	if _, ok := m.networks[name]; ok {
+		return NetworkExists
+	}
+	if m.networks == nil {
+		m.networks = make(map[string]*managedNetwork)
+	}
+	network := &managedNetwork{
+		name:      name,
+		irc:       irc,
+		stop:      make(chan empty),
+		done:      make(chan empty),
+		callbacks: make(map[uint64]CallbackID),
+	}
+	for _, callback := range m.callbacks {
+		network.addCallback(callback)
+	}
+	// keep track of why the connection was lost:
+	network.lastErrorCallback = irc.AddDisconnectCallback(func(e ircmsg.Message) {
+		if err := irc.getError(); err != nil {
+			m.setLastError(network, err)
+		}
+	})
+	m.networks[name] = network
+	go m.run(network)
+	return nil
+}
+
+func (m *Manager) run(network *managedNetwork) {
+	defer close(network.done)
+
+	irc := network.irc
+	for {
+		err := irc.Connect()
+		if err == nil {
+			if irc.isQuitting() {
+				// Quit() was called during Connect(), before QUIT could be sent
+				irc.Quit()
+			}
+			// this handles all subsequent reconnections, until Quit():
+			irc.Loop()
+			return
+		} else if err == ClientHasQuit {
+			return
+		}
+		m.setLastError(network, err)
+
+		delay := irc.ReconnectFreq
+		if delay == 0 {
+			delay = defaultReconnectFreq
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-network.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+func (m *Manager) setLastError(network *managedNetwork, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	network.lastError = err
+}
+
+// Remove stops managing the named network, disconnecting it (with Quit())
+// and waiting for its Loop to exit. The Manager's callbacks are removed
+// from the Connection.
+func (m *Manager) Remove(name string) error {
+	m.mutex.Lock()
+	network, ok := m.networks[name]
+	if ok {
+		delete(m.networks, name)
+	}
+	m.mutex.Unlock()
+
+	if !ok {
+		return NoSuchNetwork
+	}
+	network.quit()
+	<-network.done
+	network.removeCallbacks()
+	return nil
+}
+
+func (network *managedNetwork) quit() {
+	close(network.stop)
+	network.irc.Quit()
+}
+
+// unregister all of the Manager's callbacks from the network's Connection;
+// call only once the network has been removed from the Manager, after which
+// nothing else accesses network.callbacks
+func (network *managedNetwork) removeCallbacks() {
+	for _, id := range network.callbacks {
+		network.irc.RemoveCallback(id)
+	}
+	network.callbacks = nil
+	network.irc.RemoveCallback(network.lastErrorCallback)
+}
+
+// Shutdown disconnects all networks (with Quit()) and waits for their
+// Loops to exit, or for ctx to expire, in which case ctx.Err() is returned.
+// Either way, the Manager's callbacks are removed from the Connections.
+// No networks can be added afterwards.
+func (m *Manager) Shutdown(ctx context.Context) (err error) {
+	m.mutex.Lock()
+	m.shutDown = true
+	networks := make([]*managedNetwork, 0, len(m.networks))
+	for _, network := range m.networks {
+		networks = append(networks, network)
+	}
+	m.networks = nil
+	m.mutex.Unlock()
+
+	for _, network := range networks {
+		network.quit()
+	}
+	defer func() {
+		for _, network := range networks {
+			network.removeCallbacks()
+		}
+	}()
+	for _, network := range networks {
+		select {
+		case <-network.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Get returns the Connection for the named network, or nil.
+func (m *Manager) Get(name string) *Connection {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if network, ok := m.networks[name]; ok {
+		return network.irc
+	}
+	return nil
+}
+
+// Networks returns the sorted names of the managed networks.
+func (m *Manager) Networks() (result []string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	result = make([]string, 0, len(m.networks))
+	for name := range m.networks {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return
+}
+
+// Status returns the status of each managed network, sorted by name.
+func (m *Manager) Status() (result []NetworkStatus) {
+	m.mutex.Lock()
+	result = make([]NetworkStatus, 0, len(m.networks))
+	irc := make([]*Connection, 0, len(m.networks))
+	for _, network := range m.networks {
+		result = append(result, NetworkStatus{
+			Name:      network.name,
+			LastError: network.lastError,
+		})
+		irc = append(irc, network.irc)
+	}
+	m.mutex.Unlock()
+
+	// don't hold the mutex while acquiring the Connections' mutexes
+	for i := range result {
+		result[i].Connected = irc[i].Connected()
+		if result[i].Connected {
+			result[i].Nick = irc[i].CurrentNick()
+		}
+		result[i].Lag, _ = irc[i].Lag()
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return
+}
+
+// AddCallback adds a callback for an IRC command (or numeric) on all
+// managed networks, including those added later.
+func (m *Manager) AddCallback(command string, callback NetworkCallback) ManagerCallbackID {
+	return m.addCallback(managerCallback{kind: managerCallbackCommand, command: command, callback: callback})
+}
+
+// AddConnectCallback adds a callback that is run whenever any managed
+// network completes connection registration (see AddConnectCallback
+// on Connection).
+func (m *Manager) AddConnectCallback(callback NetworkCallback) ManagerCallbackID {
+	return m.addCallback(managerCallback{kind: managerCallbackConnect, callback: callback})
+}
+
+// AddDisconnectCallback adds a callback that is run whenever any managed
+// network is disconnected (see AddDisconnectCallback on Connection).
+func (m *Manager) AddDisconnectCallback(callback NetworkCallback) ManagerCallbackID {
+	return m.addCallback(managerCallback{kind: managerCallbackDisconnect, callback: callback})
+}
+
+func (m *Manager) addCallback(callback managerCallback) ManagerCallbackID {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.counter++
+	callback.id = m.counter
+	m.callbacks = append(m.callbacks, callback)
+	for _, network := range m.networks {
+		network.addCallback(callback)
+	}
+	return ManagerCallbackID{id: callback.id}
+}
+
+// RemoveCallback removes a callback from all managed networks.
+func (m *Manager) RemoveCallback(id ManagerCallbackID) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	newCallbacks := make([]managerCallback, 0, len(m.callbacks))
+	for _, callback := range m.callbacks {
+		if callback.id != id.id {
+			newCallbacks = append(newCallbacks, callback)
+		}
+	}
+	m.callbacks = newCallbacks
+	for _, network := range m.networks {
+		if callbackID, ok := network.callbacks[id.id]; ok {
+			network.irc.RemoveCallback(callbackID)
+			delete(network.callbacks, id.id)
+		}
+	}
+}
+
+// register a Manager callback on a network; call with the Manager's mutex held
+func (network *managedNetwork) addCallback(callback managerCallback) {
+	name, irc := network.name, network.irc
+	wrapper := func(e ircmsg.Message) {
+		callback.callback(name, irc, e)
+	}
+	var id CallbackID
+	switch callback.kind {
+	case managerCallbackCommand:
+		id = irc.AddCallback(callback.command, wrapper)
+	case managerCallbackConnect:
+		id = irc.AddConnectCallback(wrapper)
+	case managerCallbackDisconnect:
+		id = irc.AddDisconnectCallback(wrapper)
+	}
+	network.callbacks[callback.id] = id
+}
@@ -0,0 +1,145 @@
+package ircevent
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+func managerConnForTesting(server, nick string) *Connection {
+	return &Connection{
+		Server:        server,
+		Nick:          nick,
+		Timeout:       5 * time.Second,
+		ReconnectFreq: 10 * time.Millisecond,
+		Log:           log.New(io.Discard, "", 0),
+	}
+}
+
+// assertNoManagerCallbacks checks that a Connection no longer runs a
+// Manager's callbacks (the test Manager has a connect callback, and each
+// network has a disconnect callback)
+func assertNoManagerCallbacks(irc *Connection, connected chan managerEvent) {
+	assertEqual(len(irc.getCallbacks(disconnectEvent)), 0)
+	irc.runCallbacks(mustParse(":irc.example.com 376 alice :End of MOTD"))
+	assertEqual(len(connected), 0)
+}
+
+type managerEvent struct {
+	network string
+	nick    string
+}
+
+func TestManager(t *testing.T) {
+	bouncer := newFakeBouncer(t)
+	defer bouncer.listener.Close()
+	server := bouncer.listener.Addr().String()
+
+	// an address nothing is listening on:
+	unused, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unusedAddr := unused.Addr().String()
+	unused.Close()
+
+	connected := make(chan managerEvent, 8)
+	var manager Manager
+	manager.AddConnectCallback(func(network string, irc *Connection, e ircmsg.Message) {
+		connected <- managerEvent{network, irc.CurrentNick()}
+	})
+
+	alpha := managerConnForTesting(server, "alice")
+	assertEqual(manager.Add("alpha", alpha), nil)
+	assertEqual(<-connected, managerEvent{"alpha", "alice"})
+	assertEqual(manager.Add("alpha", managerConnForTesting(server, "alice")), NetworkExists)
+
+	var welcomes []string
+	welcomeID := manager.AddCallback(RPL_WELCOME, func(network string, irc *Connection, e ircmsg.Message) {
+		welcomes = append(welcomes, network)
+	})
+	beta := managerConnForTesting(server, "bob")
+	assertEqual(manager.Add("beta", beta), nil)
+	assertEqual(<-connected, managerEvent{"beta", "bob"})
+	assertEqual(welcomes, []string{"beta"})
+	manager.RemoveCallback(welcomeID)
+
+	assertEqual(manager.Add("broken", managerConnForTesting(unusedAddr, "carol")), nil)
+	assertEqual(manager.Networks(), []string{"alpha", "beta", "broken"})
+	assertEqual(manager.Get("beta").CurrentNick(), "bob")
+	assertEqual(manager.Get("gamma") == nil, true)
+
+	deadline := time.Now().Add(5 * time.Second)
+	var status []NetworkStatus
+	for time.Now().Before(deadline) {
+		status = manager.Status()
+		if status[2].LastError != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assertEqual(len(status), 3)
+	assertEqual(status[0].Name, "alpha")
+	assertEqual(status[0].Connected, true)
+	assertEqual(status[0].Nick, "alice")
+	assertEqual(status[0].LastError, nil)
+	assertEqual(status[2].Name, "broken")
+	assertEqual(status[2].Connected, false)
+	assertEqual(status[2].LastError != nil, true)
+
+	assertEqual(manager.Get("alpha"), alpha)
+	assertEqual(manager.Remove("alpha"), nil)
+	assertEqual(alpha.Connected(), false)
+	// the removed Connection no longer runs the Manager's callbacks:
+	assertNoManagerCallbacks(alpha, connected)
+	assertEqual(manager.Remove("alpha"), NoSuchNetwork)
+	assertEqual(manager.Networks(), []string{"beta", "broken"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assertEqual(manager.Shutdown(ctx), nil)
+	assertEqual(beta.Connected(), false)
+	assertNoManagerCallbacks(beta, connected)
+	assertEqual(len(manager.Networks()), 0)
+	assertEqual(manager.Add("gamma", managerConnForTesting(server, "dan")), ManagerShutDown)
+}
+
+func TestManagerRemoveDuringBackoff(t *testing.T) {
+	bouncer := newFakeBouncer(t)
+	irc := managerConnForTesting(bouncer.listener.Addr().String(), "alice")
+	irc.ReconnectFreq = time.Minute
+	waiting := make(chan empty, 4)
+	irc.AddStateCallback(func(change StateChange) {
+		if change.To == StateWaitingToReconnect {
+			waiting <- empty{}
+		}
+	})
+	connected := make(chan empty, 1)
+	irc.AddConnectCallback(func(e ircmsg.Message) {
+		connected <- empty{}
+	})
+
+	var manager Manager
+	assertEqual(manager.Add("alpha", irc), nil)
+	<-connected
+
+	// make the connection fail, and the immediate reconnection attempt fail too:
+	bouncer.listener.Close()
+	irc.stateMutex.Lock()
+	socket := irc.socket
+	irc.stateMutex.Unlock()
+	socket.Close()
+	// the second wait is the backoff after the failed reconnection:
+	<-waiting
+	<-waiting
+
+	start := time.Now()
+	assertEqual(manager.Remove("alpha"), nil)
+	assertEqual(time.Since(start) < time.Second, true)
+	assertEqual(irc.State(), StateDisconnected)
+}
@@ -0,0 +1,106 @@
+package ircevent
+
+import (
+	"expvar"
+	"time"
+)
+
+// Metrics receives measurements from a Connection (see Connection.Metrics),
+// e.g. to export them to a monitoring system. Its methods are called from
+// the Connection's goroutines, so they must be safe for concurrent use,
+// and must not block.
+type Metrics interface {
+	// LineReceived is called for each line read from the server, with its
+	// length in bytes (not including the line terminator).
+	LineReceived(bytes int)
+	// LineSent is called for each line written to the server, with its
+	// length in bytes (including the line terminator).
+	LineSent(bytes int)
+	// QueueDepth is called with the number of lines waiting to be sent,
+	// whenever a line is dequeued for sending.
+	QueueDepth(depth int)
+	// Reconnecting is called before each reconnection attempt made by Loop.
+	Reconnecting()
+	// CallbackPanicked is called when a panic in a callback is recovered.
+	CallbackPanicked()
+	// BatchesDropped is called with the number of incomplete batches that
+	// were discarded because the server didn't end them in time.
+	BatchesDropped(count int)
+	// Lag is called with each measurement of the round-trip time to the server.
+	Lag(lag time.Duration)
+}
+
+// ExpvarMetrics is an implementation of Metrics that exposes the
+// measurements of one Connection as an expvar.Map, with the counters
+// "lines_received", "bytes_received", "lines_sent", "bytes_sent",
+// "reconnects", "callback_panics" and "dropped_batches", and the gauges
+// "queue_depth" and "lag_seconds".
+type ExpvarMetrics struct {
+	vars *expvar.Map
+
+	linesReceived  expvar.Int
+	bytesReceived  expvar.Int
+	linesSent      expvar.Int
+	bytesSent      expvar.Int
+	reconnects     expvar.Int
+	callbackPanics expvar.Int
+	droppedBatches expvar.Int
+	queueDepth     expvar.Int
+	lag            expvar.Float
+}
+
+// NewExpvarMetrics returns a new ExpvarMetrics. If name is nonempty,
+// its variables are published under that name (which must be unique,
+// e.g. "irc.libera"); otherwise, they can be accessed with Map.
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	m := new(ExpvarMetrics)
+	m.vars = new(expvar.Map)
+	m.vars.Set("lines_received", &m.linesReceived)
+	m.vars.Set("bytes_received", &m.bytesReceived)
+	m.vars.Set("lines_sent", &m.linesSent)
+	m.vars.Set("bytes_sent", &m.bytesSent)
+	m.vars.Set("reconnects", &m.reconnects)
+	m.vars.Set("callback_panics", &m.callbackPanics)
+	m.vars.Set("dropped_batches", &m.droppedBatches)
+	m.vars.Set("queue_depth", &m.queueDepth)
+	m.vars.Set("lag_seconds", &m.lag)
+	if name != "" {
+		expvar.Publish(name, m.vars)
+	}
+	return m
+}
+
+// Map returns the expvar.Map holding the variables.
+func (m *ExpvarMetrics) Map() *expvar.Map {
+	return m.vars
+}
+
+func (m *ExpvarMetrics) LineReceived(bytes int) {
+	m.linesReceived.Add(1)
+	m.bytesReceived.Add(int64(bytes))
+}
+
+func (m *ExpvarMetrics) LineSent(bytes int) {
+	m.linesSent.Add(1)
+	m.bytesSent.Add(int64(bytes))
+}
+
+func (m *ExpvarMetrics) QueueDepth(depth int) {
+	m.queueDepth.Set(int64(depth))
+}
+
+func (m *ExpvarMetrics) Reconnecting() {
+	m.reconnects.Add(1)
+}
+
+func (m *ExpvarMetrics) CallbackPanicked() {
+	m.callbackPanics.Add(1)
+}
+
+func (m *ExpvarMetrics) BatchesDropped(count int) {
+	m.droppedBatches.Add(int64(count))
+}
+
+func (m *ExpvarMetrics) Lag(lag time.Duration) {
+	m.lag.Set(lag.Seconds())
+}
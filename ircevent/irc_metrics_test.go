@@ -0,0 +1,66 @@
+package ircevent
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+func TestExpvarMetrics(t *testing.T) {
+	metrics := NewExpvarMetrics("")
+	metrics.LineReceived(10)
+	metrics.LineReceived(20)
+	metrics.LineSent(5)
+	metrics.BatchesDropped(2)
+	metrics.Lag(1500 * time.Millisecond)
+
+	var values map[string]float64
+	if err := json.Unmarshal([]byte(metrics.Map().String()), &values); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(values["lines_received"], 2.0)
+	assertEqual(values["bytes_received"], 30.0)
+	assertEqual(values["lines_sent"], 1.0)
+	assertEqual(values["dropped_batches"], 2.0)
+	assertEqual(values["lag_seconds"], 1.5)
+	assertEqual(values["reconnects"], 0.0)
+}
+
+func TestMetricsConnection(t *testing.T) {
+	bouncer := newFakeBouncer(t)
+	defer bouncer.listener.Close()
+
+	metrics := NewExpvarMetrics("")
+	irc := &Connection{
+		Server:  bouncer.listener.Addr().String(),
+		Nick:    "bot",
+		Timeout: 5 * time.Second,
+		Log:     log.New(io.Discard, "", 0),
+		Metrics: metrics,
+	}
+	irc.AddCallback(RPL_ENDOFMOTD, func(e ircmsg.Message) {
+		panic("oops")
+	})
+	err := irc.Connect()
+	if err != nil {
+		t.Fatalf("could not connect to fake server: %v", err)
+	}
+	go irc.Loop()
+	defer irc.Quit()
+
+	// this also ensures that the read loop has processed 376:
+	lag, err := irc.MeasureLag()
+	assertEqual(err, nil)
+
+	// NICK, USER, PING; 001, 376, PONG
+	assertEqual(metrics.linesSent.Value() >= 3, true)
+	assertEqual(metrics.bytesSent.Value() > 0, true)
+	assertEqual(metrics.linesReceived.Value(), int64(3))
+	assertEqual(metrics.callbackPanics.Value(), int64(1))
+	assertEqual(metrics.lag.Value() > 0, true)
+	assertEqual(lag > 0, true)
+}
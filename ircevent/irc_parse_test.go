@@ -1,9 +1,12 @@
 package ircevent
 
 import (
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"testing"
+
+	"github.com/ergochat/irc-go/ircmsg"
 )
 
 func TestParse(t *testing.T) {
@@ -36,3 +39,21 @@ func TestUnescapeIsupport(t *testing.T) {
 	assertEqual(unescapeISupportValue(`a\x20b`), "a b")
 	assertEqual(unescapeISupportValue(`\x20\x20`), "  ")
 }
+
+func TestBatchJSON(t *testing.T) {
+	batch := &Batch{
+		Message: ircmsg.MakeMessage(map[string]string{"label": "1"}, "", "BATCH", "+a", "labeled-response"),
+		Items: []*Batch{
+			{Message: ircmsg.MakeMessage(map[string]string{"batch": "a"}, "server", "311", "nick", "real name")},
+		},
+	}
+	data, err := json.Marshal(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Batch
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(&decoded, batch)
+}
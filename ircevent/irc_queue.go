@@ -0,0 +1,253 @@
+package ircevent
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+var (
+	ErrQueueFull      = errors.New("Could not send because the send queue is full")
+	ErrMessageExpired = errors.New("Could not send before the message's deadline")
+	ErrFlushTimedOut  = errors.New("Queued messages were not sent before the timeout")
+)
+
+// control messages are not subject to SendQueueLen, and once connection
+// registration is complete, they are sent ahead of any other queued messages
+// (before then, the order of the registration commands must be preserved):
+var controlCommands = map[string]bool{
+	"PING":         true,
+	"PONG":         true,
+	"QUIT":         true,
+	"CAP":          true,
+	"AUTHENTICATE": true,
+}
+
+// SendOptions controls how a message is queued for sending;
+// see SendWithOptions.
+type SendOptions struct {
+	// if set, return ErrQueueFull instead of waiting when the send queue is full:
+	NonBlocking bool
+	// if set, the message is dropped if it can't be sent by this time
+	// (ErrMessageExpired is returned if it couldn't even be queued by then):
+	Deadline time.Time
+}
+
+type queuedLine struct {
+	line     []byte
+	deadline time.Time
+}
+
+// sendQueue holds the lines waiting to be sent by writeLoop: control lines
+// first (if prioritized is set), then the others, each in FIFO order.
+type sendQueue struct {
+	mutex       sync.Mutex
+	control     []queuedLine
+	normal      []queuedLine
+	prioritized bool       // if unset, control lines are queued in normal
+	limit       int        // maximum length of normal, not counting control lines
+	ready       chan empty // signaled (without blocking) when a line is added
+	space       chan empty // closed (and replaced) when a line is removed from normal
+	writing     bool       // a popped line is being written
+	idle        chan empty // closed (and replaced) when the queue is empty and nothing is being written
+}
+
+func newSendQueue(limit int) *sendQueue {
+	return &sendQueue{
+		limit: limit,
+		ready: make(chan empty, 1),
+		space: make(chan empty),
+		idle:  make(chan empty),
+	}
+}
+
+// push adds a line to the queue, waiting for space if necessary (and
+// options allow it), until end is closed.
+func (q *sendQueue) push(line []byte, options SendOptions, end chan empty) error {
+	item := queuedLine{line: line, deadline: options.Deadline}
+	control := controlCommands[lineCommand(line)]
+	var expired <-chan time.Time
+	for {
+		q.mutex.Lock()
+		if control && q.prioritized {
+			q.control = append(q.control, item)
+		} else if control || len(q.normal) < q.limit {
+			q.normal = append(q.normal, item)
+		} else {
+			space := q.space
+			q.mutex.Unlock()
+			if options.NonBlocking {
+				return ErrQueueFull
+			}
+			if !options.Deadline.IsZero() && expired == nil {
+				timer := time.NewTimer(time.Until(options.Deadline))
+				defer timer.Stop()
+				expired = timer.C
+			}
+			select {
+			case <-space:
+				continue
+			case <-expired:
+				return ErrMessageExpired
+			case <-end:
+				return ClientDisconnected
+			}
+		}
+		q.mutex.Unlock()
+		select {
+		case q.ready <- empty{}:
+		default:
+		}
+		return nil
+	}
+}
+
+// prioritize starts sending control lines ahead of the others.
+func (q *sendQueue) prioritize() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.prioritized = true
+}
+
+// pop removes and returns the next line to be sent, if any;
+// dropped is the number of expired lines that were discarded.
+// The line is considered to be in the process of being written
+// until the next call to pop.
+func (q *sendQueue) pop(now time.Time) (line []byte, dropped int, ok bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for len(q.control) != 0 || len(q.normal) != 0 {
+		var item queuedLine
+		if len(q.control) != 0 {
+			item = q.control[0]
+			q.control[0] = queuedLine{}
+			q.control = q.control[1:]
+		} else {
+			item = q.normal[0]
+			q.normal[0] = queuedLine{}
+			q.normal = q.normal[1:]
+			close(q.space)
+			q.space = make(chan empty)
+		}
+		if !item.deadline.IsZero() && now.After(item.deadline) {
+			dropped++
+			continue
+		}
+		q.writing = true
+		return item.line, dropped, true
+	}
+	if q.writing {
+		q.writing = false
+		close(q.idle)
+		q.idle = make(chan empty)
+	}
+	return nil, dropped, false
+}
+
+// flush waits until the queue is empty and the last line has been
+// written, or until timeout elapses or end is closed.
+func (q *sendQueue) flush(timeout time.Duration, end chan empty) error {
+	q.mutex.Lock()
+	if len(q.control) == 0 && len(q.normal) == 0 && !q.writing {
+		q.mutex.Unlock()
+		return nil
+	}
+	idle := q.idle
+	q.mutex.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-idle:
+			// lines may have been added since the queue became idle:
+			q.mutex.Lock()
+			if len(q.control) == 0 && len(q.normal) == 0 && !q.writing {
+				q.mutex.Unlock()
+				return nil
+			}
+			idle = q.idle
+			q.mutex.Unlock()
+		case <-timer.C:
+			return ErrFlushTimedOut
+		case <-end:
+			return ClientDisconnected
+		}
+	}
+}
+
+// registration commands are specific to a connection, and are never resent:
+var registrationCommands = map[string]bool{
+	"PASS":   true,
+	"WEBIRC": true,
+	"NICK":   true,
+	"USER":   true,
+}
+
+// takeUnsent empties the queue, returning the lines that can be resent
+// on a new connection: those that are neither control messages,
+// registration commands, nor labeled (their label callbacks will not
+// survive the reconnection).
+func (q *sendQueue) takeUnsent() (result []queuedLine) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for _, item := range q.normal {
+		command := lineCommand(item.line)
+		if controlCommands[command] || registrationCommands[command] {
+			continue
+		}
+		if len(item.line) != 0 && item.line[0] == '@' {
+			if msg, err := ircmsg.ParseLine(string(item.line)); err == nil && msg.HasTag("label") {
+				continue
+			}
+		}
+		result = append(result, item)
+	}
+	q.control = nil
+	q.normal = nil
+	return
+}
+
+// requeue adds lines to the queue regardless of its limit.
+func (q *sendQueue) requeue(items []queuedLine) {
+	q.mutex.Lock()
+	q.normal = append(q.normal, items...)
+	q.mutex.Unlock()
+	select {
+	case q.ready <- empty{}:
+	default:
+	}
+}
+
+// Flush waits until all queued messages have been written to the
+// connection, returning ErrFlushTimedOut if this takes longer than timeout.
+func (irc *Connection) Flush(timeout time.Duration) error {
+	irc.stateMutex.Lock()
+	running := irc.running
+	end := irc.end
+	queue := irc.sendQueue
+	irc.stateMutex.Unlock()
+
+	if !running {
+		return ClientDisconnected
+	}
+	return queue.flush(timeout, end)
+}
+
+// len returns the number of lines in the queue.
+func (q *sendQueue) len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.control) + len(q.normal)
+}
+
+// SendWithOptions sends an IRC message, queueing it according to options.
+// Control messages (PING, PONG, QUIT, CAP and AUTHENTICATE) are queued even
+// if the queue is full, and after connection registration, they are sent
+// ahead of any other queued messages.
+func (irc *Connection) SendWithOptions(options SendOptions, tags map[string]string, command string, params ...string) error {
+	return irc.sendIRCMessage(ircmsg.MakeMessage(tags, "", command, params...), options)
+}
@@ -0,0 +1,229 @@
+package ircevent
+
+import (
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestSendQueuePriority(t *testing.T) {
+	irc, sent := mockConnection()
+	irc.Privmsg("#ch", "one")
+	irc.Privmsg("#ch", "two")
+	irc.Send("PONG", "irc.example.com")
+	irc.Send("QUIT", "bye")
+	assertEqual(sent.len(), 4)
+	assertEqual(nextSent(sent), "PONG irc.example.com")
+	assertEqual(nextSent(sent), "QUIT bye")
+	assertEqual(nextSent(sent), "PRIVMSG #ch one")
+	assertEqual(nextSent(sent), "PRIVMSG #ch two")
+	assertEqual(nextSent(sent), "")
+}
+
+func TestSendQueueRegistration(t *testing.T) {
+	// before registration, control messages don't jump the queue:
+	irc, _ := mockConnection()
+	sent := newSendQueue(10)
+	irc.sendQueue = sent
+	irc.Send("WEBIRC", "password", "gateway", "example.com", "192.0.2.1")
+	irc.Send("PASS", "password")
+	irc.Send("CAP", "LS", "302")
+	irc.Send("NICK", "bot")
+	irc.Send("CAP", "END")
+	assertEqual(nextSent(sent), "WEBIRC password gateway example.com 192.0.2.1")
+	assertEqual(nextSent(sent), "PASS password")
+	assertEqual(nextSent(sent), "CAP LS 302")
+	assertEqual(nextSent(sent), "NICK bot")
+	assertEqual(nextSent(sent), "CAP END")
+}
+
+func TestSendQueueRegistrationOrder(t *testing.T) {
+	bouncer := newFakeBouncer(t)
+	defer bouncer.listener.Close()
+
+	irc := &Connection{
+		Server:       bouncer.listener.Addr().String(),
+		Nick:         "bot",
+		User:         "bot",
+		RealName:     "Bot",
+		WebIRC:       []string{"password", "gateway", "example.com", "192.0.2.1"},
+		RequestCaps:  []string{"sasl", BouncerNetworksCap},
+		UseSASL:      true,
+		SASLLogin:    "bot",
+		SASLPassword: "hunter2",
+		BouncerNetID: "7",
+		Timeout:      5 * time.Second,
+		Log:          log.New(io.Discard, "", 0),
+	}
+	err := irc.Connect()
+	if err != nil {
+		t.Fatalf("could not connect to fake server: %v", err)
+	}
+	defer irc.Quit()
+	assertEqual(bouncer.getReceived(), []string{
+		"WEBIRC password gateway example.com 192.0.2.1",
+		"CAP LS 302",
+		"CAP REQ sasl",
+		"CAP REQ " + BouncerNetworksCap,
+		"AUTHENTICATE PLAIN",
+		"AUTHENTICATE Ym90AGJvdABodW50ZXIy",
+		"BOUNCER BIND 7",
+		"CAP END",
+		"NICK bot",
+		"USER bot s e Bot",
+	})
+}
+
+func TestSendQueueFull(t *testing.T) {
+	irc, sent := mockConnection()
+	irc.sendQueue = newSendQueue(2)
+	irc.sendQueue.prioritize()
+	sent = irc.sendQueue
+	nonBlocking := SendOptions{NonBlocking: true}
+	assertEqual(irc.SendWithOptions(nonBlocking, nil, "PRIVMSG", "#ch", "one"), nil)
+	assertEqual(irc.SendWithOptions(nonBlocking, nil, "PRIVMSG", "#ch", "two"), nil)
+	assertEqual(irc.SendWithOptions(nonBlocking, nil, "PRIVMSG", "#ch", "three"), ErrQueueFull)
+	// control messages are exempt from the limit:
+	assertEqual(irc.SendWithOptions(nonBlocking, nil, "PONG", "irc.example.com"), nil)
+
+	// a blocking send waits for space:
+	result := make(chan error, 1)
+	go func() {
+		result <- irc.Privmsg("#ch", "three")
+	}()
+	time.Sleep(10 * time.Millisecond)
+	assertEqual(len(result), 0)
+	assertEqual(nextSent(sent), "PONG irc.example.com")
+	assertEqual(nextSent(sent), "PRIVMSG #ch one")
+	assertEqual(<-result, nil)
+	assertEqual(sent.len(), 2)
+
+	// unless its deadline passes first:
+	deadline := SendOptions{Deadline: time.Now().Add(10 * time.Millisecond)}
+	assertEqual(irc.SendWithOptions(deadline, nil, "PRIVMSG", "#ch", "four"), ErrMessageExpired)
+
+	go func() {
+		result <- irc.Privmsg("#ch", "four")
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(irc.end)
+	assertEqual(<-result, ClientDisconnected)
+}
+
+func TestSendQueueDeadline(t *testing.T) {
+	irc, sent := mockConnection()
+	now := time.Now()
+	irc.SendWithOptions(SendOptions{Deadline: now.Add(time.Second)}, nil, "PRIVMSG", "#ch", "one")
+	irc.SendWithOptions(SendOptions{Deadline: now.Add(time.Minute)}, nil, "PRIVMSG", "#ch", "two")
+	irc.Privmsg("#ch", "three")
+
+	line, dropped, ok := sent.pop(now.Add(2 * time.Second))
+	assertEqual(string(line), "PRIVMSG #ch two\r\n")
+	assertEqual(dropped, 1)
+	assertEqual(ok, true)
+	assertEqual(nextSent(sent), "PRIVMSG #ch three")
+}
+
+func TestSendQueueFlush(t *testing.T) {
+	irc, sent := mockConnection()
+	assertEqual(irc.Flush(time.Second), nil)
+
+	irc.Privmsg("#ch", "one")
+	irc.Privmsg("#ch", "two")
+	assertEqual(irc.Flush(10*time.Millisecond), ErrFlushTimedOut)
+
+	result := make(chan error, 1)
+	go func() {
+		result <- irc.Flush(5 * time.Second)
+	}()
+	assertEqual(nextSent(sent), "PRIVMSG #ch one")
+	assertEqual(nextSent(sent), "PRIVMSG #ch two")
+	time.Sleep(10 * time.Millisecond)
+	// the last line is still being written:
+	assertEqual(len(result), 0)
+	assertEqual(nextSent(sent), "")
+	assertEqual(<-result, nil)
+}
+
+func TestSendQueueResendOnReconnect(t *testing.T) {
+	irc, _ := mockConnection()
+	irc.ResendOnReconnect = true
+	irc.registered = true
+	irc.Privmsg("#ch", "one")
+	irc.Send("PONG", "irc.example.com")
+	irc.Send("NICK", "bot2")
+	irc.SendWithTags(map[string]string{"label": "abc"}, "WHO", "#ch")
+	irc.Privmsg("#ch", "two")
+	irc.closeEnd()
+	irc.waitForStop()
+	assertEqual(len(irc.unsent), 2)
+
+	// simulate a new connection:
+	sent := newSendQueue(64)
+	irc.running = true
+	irc.end = make(chan empty)
+	irc.sendQueue = sent
+	irc.runCallbacks(mustParse(":irc.example.com 001 go-eventirc :Welcome"))
+	assertEqual(sent.len(), 0)
+	irc.runCallbacks(mustParse(":irc.example.com 376 go-eventirc :End of MOTD"))
+	assertEqual(nextSent(sent), "PRIVMSG #ch one")
+	assertEqual(nextSent(sent), "PRIVMSG #ch two")
+	assertEqual(nextSent(sent), "")
+	assertEqual(len(irc.unsent), 0)
+}
+
+func TestSendQueueResendAfterFailedRegistration(t *testing.T) {
+	irc, sent := mockConnection()
+	irc.ResendOnReconnect = true
+	// a connection that fails during registration, as cleaned up by Connect():
+	sent.prioritized = false
+	irc.Send("WEBIRC", "password", "gateway", "example.com", "192.0.2.1")
+	irc.Send("PASS", "password")
+	irc.Send("CAP", "LS", "302")
+	irc.Send("AUTHENTICATE", "PLAIN")
+	irc.Send("BOUNCER", "BIND", "7")
+	irc.Send("NICK", "bot")
+	irc.Send("USER", "bot", "s", "e", "bot")
+	irc.closeEnd()
+	irc.waitForStop()
+	assertEqual(len(irc.unsent), 0)
+
+	// the next connection succeeds:
+	sent = newSendQueue(64)
+	irc.running = true
+	irc.end = make(chan empty)
+	irc.sendQueue = sent
+	irc.registered = true
+	irc.runCallbacks(mustParse(":irc.example.com 376 bot :End of MOTD"))
+	assertEqual(nextSent(sent), "")
+}
+
+func TestSendQueueQuitWithFlush(t *testing.T) {
+	bouncer := newFakeBouncer(t)
+	defer bouncer.listener.Close()
+
+	irc := &Connection{
+		Server:  bouncer.listener.Addr().String(),
+		Nick:    "bot",
+		Timeout: 5 * time.Second,
+		Log:     log.New(io.Discard, "", 0),
+	}
+	err := irc.Connect()
+	if err != nil {
+		t.Fatalf("could not connect to fake server: %v", err)
+	}
+	done := make(chan empty)
+	go func() {
+		irc.Loop()
+		close(done)
+	}()
+	for i := 0; i < 20; i++ {
+		irc.Privmsg("#ch", "hi")
+	}
+	assertEqual(irc.Flush(5*time.Second), nil)
+	assertEqual(irc.sendQueue.len(), 0)
+	irc.Privmsg("#ch", "bye")
+	assertEqual(irc.QuitWithFlush(5*time.Second), nil)
+	<-done
+}
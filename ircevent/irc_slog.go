@@ -0,0 +1,32 @@
+//go:build go1.21
+// +build go1.21
+
+package ircevent
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+type slogLogger struct {
+	handler slog.Handler
+}
+
+// NewSlogLogger returns a StructuredLogger (for use as Connection.Logger)
+// that passes events to a log/slog Handler, e.g. slog.NewJSONHandler.
+func NewSlogLogger(handler slog.Handler) StructuredLogger {
+	return &slogLogger{handler: handler}
+}
+
+func (l *slogLogger) Enabled(level LogLevel) bool {
+	return l.handler.Enabled(context.Background(), slog.Level(level))
+}
+
+func (l *slogLogger) Log(level LogLevel, msg string, attrs ...LogAttr) {
+	record := slog.NewRecord(time.Now(), slog.Level(level), msg, 0)
+	for _, attr := range attrs {
+		record.AddAttrs(slog.Any(attr.Key, attr.Value))
+	}
+	l.handler.Handle(context.Background(), record)
+}
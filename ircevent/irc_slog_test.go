@@ -0,0 +1,32 @@
+//go:build go1.21
+// +build go1.21
+
+package ircevent
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewSlogLogger(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	assertEqual(logger.Enabled(LogLevelDebug), false)
+	assertEqual(logger.Enabled(LogLevelWarn), true)
+
+	irc := &Connection{Server: "irc.example.com:6697", Nick: "bot", Logger: logger}
+	irc.log(LogLevelWarn, "batch error", []LogAttr{{"error", errors.New("oops")}, {"batch", "xyz"}}, "")
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(record["level"], "WARN")
+	assertEqual(record["msg"], "batch error")
+	assertEqual(record["server"], "irc.example.com:6697")
+	assertEqual(record["nick"], "bot")
+	assertEqual(record["error"], "oops")
+	assertEqual(record["batch"], "xyz")
+}
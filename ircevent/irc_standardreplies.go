@@ -0,0 +1,91 @@
+package ircevent
+
+import (
+	"strings"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+const (
+	// fake event for managing standard reply callbacks
+	standardReplyEvent = "\x00STANDARDREPLY"
+)
+
+// StandardReply is a parsed IRCv3 standard reply (FAIL, WARN or NOTE), as
+// per https://ircv3.net/specs/extensions/standard-replies . It implements
+// error, so that a FAIL can be returned (e.g. by GetLabeledResponse) and
+// inspected with errors.As.
+type StandardReply struct {
+	Type        string   // "FAIL", "WARN" or "NOTE"
+	Command     string   // the command the reply relates to, or "*"
+	Code        string   // machine-readable code, e.g. "NEED_REGISTRATION"
+	Context     []string // additional parameters, depending on the code
+	Description string   // human-readable description
+}
+
+// ParseStandardReply parses a FAIL, WARN or NOTE message; ok is false
+// if the message is not a valid standard reply.
+func ParseStandardReply(msg ircmsg.Message) (reply StandardReply, ok bool) {
+	switch msg.Command {
+	case "FAIL", "WARN", "NOTE":
+	default:
+		return
+	}
+	// <type> <command> <code> [<context>...] <description>
+	if len(msg.Params) < 3 {
+		return
+	}
+	reply.Type = msg.Command
+	reply.Command = msg.Params[0]
+	reply.Code = msg.Params[1]
+	if len(msg.Params) > 3 {
+		reply.Context = msg.Params[2 : len(msg.Params)-1]
+	}
+	reply.Description = msg.Params[len(msg.Params)-1]
+	return reply, true
+}
+
+// Error implements error.
+func (reply StandardReply) Error() string {
+	var buf strings.Builder
+	buf.WriteString(reply.Type)
+	buf.WriteByte(' ')
+	buf.WriteString(reply.Command)
+	buf.WriteByte(' ')
+	buf.WriteString(reply.Code)
+	for _, context := range reply.Context {
+		buf.WriteByte(' ')
+		buf.WriteString(context)
+	}
+	buf.WriteString(": ")
+	buf.WriteString(reply.Description)
+	return buf.String()
+}
+
+// AddStandardReplyCallback adds a callback for standard replies (FAIL, WARN
+// and NOTE) received from the server. Standard replies that are part of a
+// labeled response are delivered to the label callback instead. The callback
+// can be removed as usual with RemoveCallback.
+func (irc *Connection) AddStandardReplyCallback(callback func(StandardReply, ircmsg.Message)) (id CallbackID) {
+	wrapper := func(e ircmsg.Message) {
+		if reply, ok := ParseStandardReply(e); ok {
+			callback(reply, e)
+		}
+	}
+	// XXX: forcibly use the same ID number for all copies of the callback
+	idFail := irc.AddCallback("FAIL", wrapper)
+	irc.addCallback("WARN", wrapper, false, idFail.id)
+	irc.addCallback("NOTE", wrapper, false, idFail.id)
+	return CallbackID{command: standardReplyEvent, id: idFail.id}
+}
+
+// findFail returns the first FAIL in a (possibly nested) batch.
+func findFail(batch *Batch) (reply StandardReply, ok bool) {
+	findInBatch(batch, func(msg *ircmsg.Message) bool {
+		if msg.Command == "FAIL" {
+			reply, ok = ParseStandardReply(*msg)
+		}
+		return ok
+	})
+	return
+}
@@ -0,0 +1,99 @@
+package ircevent
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+func TestStandardReplyParse(t *testing.T) {
+	reply, ok := ParseStandardReply(mustParse("FAIL PRIVMSG INVALID_TARGET #chan :No such channel"))
+	assertEqual(ok, true)
+	assertEqual(reply.Type, "FAIL")
+	assertEqual(reply.Command, "PRIVMSG")
+	assertEqual(reply.Code, "INVALID_TARGET")
+	assertEqual(reply.Context, []string{"#chan"})
+	assertEqual(reply.Description, "No such channel")
+	assertEqual(reply.Error(), "FAIL PRIVMSG INVALID_TARGET #chan: No such channel")
+
+	reply, ok = ParseStandardReply(mustParse("NOTE * SERVER_RESTARTING :Restarting soon"))
+	assertEqual(ok, true)
+	assertEqual(len(reply.Context), 0)
+
+	_, ok = ParseStandardReply(mustParse("FAIL REGISTER :oops"))
+	assertEqual(ok, false)
+	_, ok = ParseStandardReply(mustParse("PRIVMSG #chan :hi"))
+	assertEqual(ok, false)
+}
+
+func TestStandardReplyCallback(t *testing.T) {
+	irc, _ := mockConnection()
+	var replies []StandardReply
+	id := irc.AddStandardReplyCallback(func(reply StandardReply, e ircmsg.Message) {
+		replies = append(replies, reply)
+	})
+	irc.HandleMessage(mustParse(":irc.example.com FAIL JOIN NEED_REGISTRATION :You must register"))
+	irc.HandleMessage(mustParse(":irc.example.com WARN * SLOW :Slow down"))
+	irc.HandleMessage(mustParse(":irc.example.com FAIL"))
+	assertEqual(len(replies), 2)
+	assertEqual(replies[0].Code, "NEED_REGISTRATION")
+	assertEqual(replies[1].Type, "WARN")
+
+	irc.RemoveCallback(id)
+	irc.HandleMessage(mustParse(":irc.example.com NOTE * SOMETHING :Something"))
+	assertEqual(len(replies), 2)
+}
+
+func TestStandardReplyLabeledResponse(t *testing.T) {
+	irc, sent := mockConnection()
+	irc.capFlags = capFlagBatch | capFlagLabeledResponse
+	irc.batches = make(map[string]batchInProgress)
+	irc.labelCallbacks = make(map[int64]pendingLabel)
+
+	type result struct {
+		batch *Batch
+		err   error
+	}
+	results := make(chan result, 1)
+	query := func(params ...string) {
+		go func() {
+			batch, err := irc.GetLabeledResponse(nil, "PRIVMSG", params...)
+			results <- result{batch, err}
+		}()
+	}
+
+	query("#nonexistent", "hi")
+	line := waitSent(sent)
+	msg, _ := ircmsg.ParseLine(string(line))
+	_, label := msg.GetTag("label")
+	irc.runCallbacks(mustParse("@label=" + label + " :irc.example.com FAIL PRIVMSG INVALID_TARGET #nonexistent :No such channel"))
+	r := <-results
+	var reply StandardReply
+	assertEqual(errors.As(r.err, &reply), true)
+	assertEqual(reply.Code, "INVALID_TARGET")
+	assertEqual(r.batch.Command, "FAIL")
+
+	// FAIL inside a batch:
+	query("#chan", "hi")
+	line = waitSent(sent)
+	msg, _ = ircmsg.ParseLine(string(line))
+	_, label = msg.GetTag("label")
+	irc.runCallbacks(mustParse("@label=" + label + " :irc.example.com BATCH +a labeled-response"))
+	irc.runCallbacks(mustParse("@batch=a :irc.example.com NOTE PRIVMSG SLOW :Slow down"))
+	irc.runCallbacks(mustParse("@batch=a :irc.example.com FAIL PRIVMSG CANNOT_SEND #chan :Cannot send"))
+	irc.runCallbacks(mustParse(":irc.example.com BATCH -a"))
+	r = <-results
+	assertEqual(errors.As(r.err, &reply), true)
+	assertEqual(reply.Code, "CANNOT_SEND")
+	assertEqual(len(r.batch.Items), 2)
+
+	// success:
+	query("#chan", "hi")
+	line = waitSent(sent)
+	msg, _ = ircmsg.ParseLine(string(line))
+	_, label = msg.GetTag("label")
+	irc.runCallbacks(mustParse("@label=" + label + " :irc.example.com ACK"))
+	r = <-results
+	assertEqual(r.err, nil)
+}
@@ -0,0 +1,150 @@
+package ircevent
+
+const (
+	// fake event for managing state callbacks
+	stateEvent = "\x00STATE"
+)
+
+// State is the state of a Connection's connection to the server.
+type State uint
+
+const (
+	StateDisconnected       State = iota // not connected (the initial state)
+	StateDialing                         // opening the connection
+	StateTLSHandshake                    // performing the TLS handshake
+	StateNegotiatingCaps                 // negotiating IRCv3 capabilities
+	StateAuthenticating                  // performing SASL authentication
+	StateRegistering                     // sent NICK and USER, awaiting the end of registration
+	StateRegistered                      // connection registration is complete
+	StateQuitting                        // Quit() was called, awaiting disconnection
+	StateWaitingToReconnect              // waiting (ReconnectFreq) before reconnecting
+)
+
+var stateNames = [...]string{
+	StateDisconnected:       "disconnected",
+	StateDialing:            "dialing",
+	StateTLSHandshake:       "tls-handshake",
+	StateNegotiatingCaps:    "negotiating-caps",
+	StateAuthenticating:     "authenticating",
+	StateRegistering:        "registering",
+	StateRegistered:         "registered",
+	StateQuitting:           "quitting",
+	StateWaitingToReconnect: "waiting-to-reconnect",
+}
+
+func (state State) String() string {
+	if int(state) < len(stateNames) {
+		return stateNames[state]
+	}
+	return "unknown"
+}
+
+// StateChange describes a transition between states. For a transition to
+// StateDisconnected, Err is the error that caused it, if any.
+type StateChange struct {
+	From State
+	To   State
+	Err  error
+}
+
+type stateCallbackPair struct {
+	id       uint64
+	callback func(StateChange)
+}
+
+// State returns the current state of the connection.
+func (irc *Connection) State() State {
+	irc.stateMutex.Lock()
+	defer irc.stateMutex.Unlock()
+	return irc.state
+}
+
+// AddStateCallback adds a callback that is run on every change of state.
+// Changes are delivered one at a time, in the order they happened, so the
+// From of each change is the To of the previous one. The callback runs
+// synchronously on the goroutine that caused the change (e.g. the one that
+// called Connect, Loop or Quit), or on another goroutine that is already
+// delivering an earlier change, and must not block. The callback can be
+// removed as usual with RemoveCallback.
+func (irc *Connection) AddStateCallback(callback func(StateChange)) CallbackID {
+	irc.eventsMutex.Lock()
+	defer irc.eventsMutex.Unlock()
+
+	irc.callbackCounter++
+	idNum := irc.callbackCounter
+	newList := make([]stateCallbackPair, len(irc.stateCallbacks)+1)
+	copy(newList, irc.stateCallbacks)
+	newList[len(newList)-1] = stateCallbackPair{id: idNum, callback: callback}
+	irc.stateCallbacks = newList
+	return CallbackID{command: stateEvent, id: idNum}
+}
+
+func (irc *Connection) removeStateCallbackNoMutex(idNum uint64) {
+	newList := make([]stateCallbackPair, 0, len(irc.stateCallbacks))
+	for _, p := range irc.stateCallbacks {
+		if p.id != idNum {
+			newList = append(newList, p)
+		}
+	}
+	irc.stateCallbacks = newList
+}
+
+// setState changes the state, running the state callbacks if it changed;
+// don't call with stateMutex held.
+func (irc *Connection) setState(state State, err error) {
+	irc.stateMutex.Lock()
+	from := irc.state
+	// Quit() on a connection that isn't connected doesn't change the state:
+	if from == state || (from == StateDisconnected && state == StateQuitting) {
+		irc.stateMutex.Unlock()
+		return
+	}
+	irc.state = state
+	irc.pendingStateChanges = append(irc.pendingStateChanges, StateChange{From: from, To: state, Err: err})
+	if irc.deliveringStateChanges {
+		// the goroutine delivering the earlier changes will deliver this one
+		irc.stateMutex.Unlock()
+		return
+	}
+	irc.deliveringStateChanges = true
+	irc.stateMutex.Unlock()
+
+	delivered := false
+	defer func() {
+		// a callback panicked and AllowPanic is set:
+		if !delivered {
+			irc.stateMutex.Lock()
+			irc.deliveringStateChanges = false
+			irc.stateMutex.Unlock()
+		}
+	}()
+	for {
+		irc.stateMutex.Lock()
+		if len(irc.pendingStateChanges) == 0 {
+			irc.deliveringStateChanges = false
+			irc.pendingStateChanges = nil
+			irc.stateMutex.Unlock()
+			delivered = true
+			return
+		}
+		change := irc.pendingStateChanges[0]
+		irc.pendingStateChanges = irc.pendingStateChanges[1:]
+		irc.stateMutex.Unlock()
+
+		irc.runStateCallbacks(change)
+	}
+}
+
+func (irc *Connection) runStateCallbacks(change StateChange) {
+	if !irc.AllowPanic {
+		defer irc.handleCallbackPanic()
+	}
+
+	irc.eventsMutex.Lock()
+	callbacks := irc.stateCallbacks
+	irc.eventsMutex.Unlock()
+
+	for _, pair := range callbacks {
+		pair.callback(change)
+	}
+}
@@ -0,0 +1,148 @@
+package ircevent
+
+import (
+	"io"
+	"log"
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStateString(t *testing.T) {
+	assertEqual(StateDisconnected.String(), "disconnected")
+	assertEqual(StateRegistered.String(), "registered")
+	assertEqual(StateWaitingToReconnect.String(), "waiting-to-reconnect")
+	assertEqual(State(1000).String(), "unknown")
+}
+
+func nextStateChange(t *testing.T, changes chan StateChange) StateChange {
+	select {
+	case change := <-changes:
+		return change
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for state change")
+		return StateChange{}
+	}
+}
+
+func TestStateTransitions(t *testing.T) {
+	bouncer := newFakeBouncer(t)
+	defer bouncer.listener.Close()
+
+	irc := &Connection{
+		Server:      bouncer.listener.Addr().String(),
+		Nick:        "bot",
+		RequestCaps: []string{"message-tags"},
+		Timeout:     5 * time.Second,
+		Log:         log.New(io.Discard, "", 0),
+	}
+	assertEqual(irc.State(), StateDisconnected)
+	changes := make(chan StateChange, 16)
+	id := irc.AddStateCallback(func(change StateChange) {
+		changes <- change
+	})
+
+	err := irc.Connect()
+	if err != nil {
+		t.Fatalf("could not connect to fake server: %v", err)
+	}
+	assertEqual(irc.State(), StateRegistered)
+	expected := []State{StateDialing, StateNegotiatingCaps, StateRegistering, StateRegistered}
+	from := StateDisconnected
+	for _, state := range expected {
+		change := nextStateChange(t, changes)
+		assertEqual(change, StateChange{From: from, To: state})
+		from = state
+	}
+
+	go irc.Loop()
+	irc.Quit()
+	assertEqual(nextStateChange(t, changes).To, StateQuitting)
+	assertEqual(nextStateChange(t, changes).To, StateDisconnected)
+	assertEqual(irc.State(), StateDisconnected)
+
+	irc.RemoveCallback(id)
+	irc.setState(StateDialing, nil)
+	assertEqual(len(changes), 0)
+}
+
+func TestStateConnectFailure(t *testing.T) {
+	// an address nothing is listening on:
+	unused, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unusedAddr := unused.Addr().String()
+	unused.Close()
+
+	irc := &Connection{
+		Server:  unusedAddr,
+		Nick:    "bot",
+		Timeout: 5 * time.Second,
+		Log:     log.New(io.Discard, "", 0),
+	}
+	changes := make(chan StateChange, 16)
+	irc.AddStateCallback(func(change StateChange) {
+		changes <- change
+	})
+	err = irc.Connect()
+	assertEqual(err != nil, true)
+	assertEqual(nextStateChange(t, changes).To, StateDialing)
+	change := nextStateChange(t, changes)
+	assertEqual(change.To, StateDisconnected)
+	assertEqual(change.Err, err)
+}
+
+func TestStateQuitWithoutConnecting(t *testing.T) {
+	irc := &Connection{
+		Nick: "bot",
+		Log:  log.New(io.Discard, "", 0),
+	}
+	changes := make(chan StateChange, 16)
+	irc.AddStateCallback(func(change StateChange) {
+		changes <- change
+	})
+	irc.Quit()
+	assertEqual(irc.State(), StateDisconnected)
+	assertEqual(len(changes), 0)
+}
+
+func TestStateChangeOrder(t *testing.T) {
+	irc := &Connection{
+		Nick: "bot",
+		Log:  log.New(io.Discard, "", 0),
+	}
+	var changes []StateChange
+	irc.AddStateCallback(func(change StateChange) {
+		// changes are delivered one at a time, so this needs no locking:
+		changes = append(changes, change)
+		runtime.Gosched()
+		if change.To == StateRegistering {
+			// a change made by a callback is delivered after this one:
+			irc.setState(StateRegistered, nil)
+		}
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				irc.setState(StateDialing, nil)
+				irc.setState(StateRegistering, nil)
+				irc.setState(StateDisconnected, nil)
+			}
+		}()
+	}
+	wg.Wait()
+
+	from := StateDisconnected
+	for _, change := range changes {
+		assertEqual(change.From, from)
+		from = change.To
+	}
+	assertEqual(from, irc.State())
+}
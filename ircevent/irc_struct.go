@@ -7,6 +7,7 @@ package ircevent
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"log"
 	"net"
 	"strings"
@@ -53,13 +54,15 @@ type Connection struct {
 	SASLLogin       string   // SASL credentials to log in with (failure is fatal by default)
 	SASLPassword    string
 	SASLMech        string
-	SASLOptional    bool // make SASL failure non-fatal
+	SASLOptional    bool   // make SASL failure non-fatal
+	BouncerNetID    string // if set, bind to this soju.im/bouncer-networks network
 	QuitMessage     string
 	Version         string
 	Timeout         time.Duration
 	KeepAlive       time.Duration
 	ReconnectFreq   time.Duration
 	MaxLineLen      int // maximum line length, not including tags
+	SendQueueLen    int // maximum number of messages waiting to be sent (default 10)
 	UseTLS          bool
 	UseSASL         bool
 	EnableCTCP      bool
@@ -68,12 +71,34 @@ type Connection struct {
 	AllowTruncation bool // if set, truncate lines exceeding MaxLineLen and send them
 	// set this to configure how the connection is made (e.g. via a proxy server):
 	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	// if set, messages left unsent when a registered connection is lost are sent
+	// again after reconnecting (except control, registration and labeled messages):
+	ResendOnReconnect bool
+
+	// CTCP reply policy, if EnableCTCP is set:
+	CTCPReplyToChannels bool          // reply (privately) to CTCP requests sent to channels
+	CTCPReplyInterval   time.Duration // average interval between replies (default 1s, negative to disable)
+
+	// if set, and echo-message is negotiated, echoes of our own messages
+	// are not passed to callbacks:
+	SuppressEchoes bool
+
+	// if set, messages with a msgid that was already recorded in this
+	// store are dropped (e.g. when history fetched after a reconnect
+	// overlaps with messages that were seen live):
+	DedupeStore MsgIDStore
+
+	// keepalive health policy:
+	LagThreshold   time.Duration // if nonzero, run lag callbacks when the lag crosses this
+	MaxMissedPongs int           // consecutive unanswered keepalive PINGs before disconnecting (default 1)
 
 	// networking and synchronization
 	stateMutex sync.Mutex     // innermost mutex: don't block while holding this
 	end        chan empty     // closing this causes the goroutines to exit
-	pwrite     chan []byte    // receives IRC lines to be sent to the socket
+	sendQueue  *sendQueue     // IRC lines waiting to be sent to the socket
+	unsent     []queuedLine   // lines left in sendQueue by the previous connection
 	reconnSig  chan empty     // interrupts sleep in between reconnects (#79)
+	quitSig    chan empty     // closed by Quit(), also interrupting that sleep
 	wg         sync.WaitGroup // after closing end, wait on this for all the goroutines to stop
 	socket     net.Conn
 	lastError  error
@@ -81,6 +106,19 @@ type Connection struct {
 	running    bool      // is a connection active? is `end` open?
 	quit       bool      // user called Quit, do not reconnect
 	pingSent   bool      // we sent PING and are waiting for PONG
+	state      State     // for observability; see setState
+	pingSentAt time.Time // when the oldest unanswered keepalive PING was sent
+
+	// state changes awaiting delivery to the state callbacks (see setState):
+	pendingStateChanges    []StateChange
+	deliveringStateChanges bool // is a goroutine delivering them?
+
+	missedPongs int                           // consecutive keepalive PINGs left unanswered
+	lagExceeded bool                          // is the lag currently above LagThreshold?
+	pingWaiters map[string]chan time.Duration // MeasureLag calls awaiting PONG
+
+	keepaliveSamples []keepaliveSample // recent keepalive round trips, oldest first
+	lastReceivedAt   time.Time         // time the line currently being processed was received
 
 	// IRC protocol connection state
 	currentNick     string // nickname assigned by the server, empty before registration
@@ -107,14 +145,24 @@ type Connection struct {
 	callbackCounter uint64
 	// did we initialize the callbacks needed for the library itself?
 	batchCallbacks   []batchCallbackPair
+	lagCallbacks     []lagCallbackPair
+	stateCallbacks   []stateCallbackPair
 	hasBaseCallbacks bool
 
 	batchMutex     sync.Mutex
 	batches        map[string]batchInProgress
 	labelCallbacks map[int64]pendingLabel
 	labelCounter   int64
+	pendingEchoes  []*pendingEcho // unlabeled SendAndConfirm calls, in order
+
+	ctcpHandlers map[string]CTCPHandler // protected by eventsMutex
+	ctcpReplyTAT time.Time              // rate limiting for CTCP replies; protected by stateMutex
 
 	Log *log.Logger
+	// if set, events are logged here (with structured attributes) instead of to Log:
+	Logger StructuredLogger
+	// if set, measurements of the connection (lines sent and received, lag, etc.) are reported here:
+	Metrics Metrics
 }
 
 type batchInProgress struct {
@@ -146,17 +194,39 @@ type Batch struct {
 	Items []*Batch
 }
 
+type jsonBatch struct {
+	Message ircmsg.Message `json:"message"`
+	Items   []*Batch       `json:"items,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler. (Without this, the embedded
+// Message's MarshalJSON would be promoted and Items would be lost.)
+func (b Batch) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonBatch{Message: b.Message, Items: b.Items})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (b *Batch) UnmarshalJSON(data []byte) (err error) {
+	var jb jsonBatch
+	if err = json.Unmarshal(data, &jb); err != nil {
+		return
+	}
+	b.Message, b.Items = jb.Message, jb.Items
+	return nil
+}
+
 const (
 	capFlagBatch uint32 = 1 << iota
 	capFlagMessageTags
 	capFlagLabeledResponse
 	capFlagMultiline
+	capFlagEchoMessage
 )
 
 func (irc *Connection) processAckedCaps(acknowledgedCaps []string) {
 	irc.stateMutex.Lock()
 	defer irc.stateMutex.Unlock()
-	var hasBatch, hasLabel, hasTags, hasMultiline bool
+	var hasBatch, hasLabel, hasTags, hasMultiline, hasEcho bool
 	for _, c := range acknowledgedCaps {
 		irc.capsAcked[c] = irc.capsAdvertised[c]
 		switch c {
@@ -168,6 +238,8 @@ func (irc *Connection) processAckedCaps(acknowledgedCaps []string) {
 			hasTags = true
 		case "draft/multiline", "multiline":
 			hasMultiline = true
+		case "echo-message":
+			hasEcho = true
 		}
 	}
 
@@ -184,6 +256,9 @@ func (irc *Connection) processAckedCaps(acknowledgedCaps []string) {
 	if hasTags && hasBatch && hasMultiline {
 		capFlags |= capFlagMultiline
 	}
+	if hasEcho {
+		capFlags |= capFlagEchoMessage
+	}
 
 	atomic.StoreUint32(&irc.capFlags, capFlags)
 }
@@ -196,6 +271,10 @@ func (irc *Connection) labelNegotiated() bool {
 	return atomic.LoadUint32(&irc.capFlags)&capFlagLabeledResponse != 0
 }
 
+func (irc *Connection) echoNegotiated() bool {
+	return atomic.LoadUint32(&irc.capFlags)&capFlagEchoMessage != 0
+}
+
 // GetReplyTarget attempts to determine where replies to a PRIVMSG or NOTICE
 // should be sent (a channel if the message was sent to a channel, a nick
 // if the message was a direct message from a valid nickname). If no valid
@@ -207,14 +286,8 @@ func (irc *Connection) GetReplyTarget(msg ircmsg.Message) string {
 			return ""
 		}
 		target := msg.Params[0]
-		chanTypes := irc.ISupport()["CHANTYPES"]
-		if chanTypes == "" {
-			chanTypes = "#"
-		}
-		for i := 0; i < len(chanTypes); i++ {
-			if strings.HasPrefix(target, chanTypes[i:i+1]) {
-				return target
-			}
+		if irc.isChannel(target) {
+			return target
 		}
 		// this was not a channel message: attempt to reply to the source
 		if nuh, err := msg.NUH(); err == nil {
@@ -232,6 +305,16 @@ func (irc *Connection) GetReplyTarget(msg ircmsg.Message) string {
 	}
 }
 
+// isChannel determines whether a target is a channel name, according to
+// the CHANTYPES advertised by the server.
+func (irc *Connection) isChannel(target string) bool {
+	chanTypes := irc.ISupport()["CHANTYPES"]
+	if chanTypes == "" {
+		chanTypes = "#"
+	}
+	return target != "" && strings.IndexByte(chanTypes, target[0]) != -1
+}
+
 // Deprecated; use (*ircmsg.Message).Nick() instead
 func ExtractNick(source string) string {
 	nuh, err := ircmsg.ParseNUH(source)
@@ -2,6 +2,8 @@ package ircevent
 
 import (
 	"crypto/tls"
+	"io"
+	"log"
 	"math/rand"
 	"sort"
 	"strings"
@@ -31,6 +33,40 @@ func mockEvent(command string) ircmsg.Message {
 	return ircmsg.MakeMessage(nil, ":server.name", command)
 }
 
+// mockConnection returns a Connection that is "running" without a socket;
+// lines sent on it can be read from the returned queue.
+func mockConnection() (irc *Connection, sent *sendQueue) {
+	irc = &Connection{
+		Nick:    "go-eventirc",
+		Version: Version,
+		Log:     log.New(io.Discard, "", 0),
+	}
+	sent = newSendQueue(64)
+	sent.prioritize()
+	irc.running = true
+	irc.end = make(chan empty)
+	irc.sendQueue = sent
+	irc.currentNick = irc.Nick
+	return
+}
+
+// nextSent returns the next line sent on a mockConnection (without \r\n),
+// or the empty string if nothing was sent.
+func nextSent(sent *sendQueue) string {
+	line, _, _ := sent.pop(time.Now())
+	return strings.TrimSuffix(string(line), "\r\n")
+}
+
+// waitSent waits for the next line sent on a mockConnection.
+func waitSent(sent *sendQueue) []byte {
+	for {
+		if line, _, ok := sent.pop(time.Now()); ok {
+			return line
+		}
+		<-sent.ready
+	}
+}
+
 func TestRemoveCallback(t *testing.T) {
 	irccon := connForTesting("go-eventirc", "go-eventirc", false)
 	debugTest(irccon)
@@ -0,0 +1,75 @@
+package ircevent
+
+import (
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+const (
+	// number of keepalive PING/PONG round trips to remember
+	maxKeepaliveSamples = 8
+)
+
+// keepaliveSample records a single keepalive PING/PONG round trip.
+type keepaliveSample struct {
+	receivedAt time.Time     // local time the PONG was received
+	rtt        time.Duration // round-trip time
+	offset     time.Duration // server clock minus local clock, if hasOffset
+	hasOffset  bool          // did the PONG carry a server-time tag?
+}
+
+// record a keepalive round trip; call with stateMutex held
+func (irc *Connection) addKeepaliveSampleNoMutex(sample keepaliveSample) {
+	if len(irc.keepaliveSamples) == maxKeepaliveSamples {
+		copy(irc.keepaliveSamples, irc.keepaliveSamples[1:])
+		irc.keepaliveSamples = irc.keepaliveSamples[:maxKeepaliveSamples-1]
+	}
+	irc.keepaliveSamples = append(irc.keepaliveSamples, sample)
+}
+
+// ClockOffset estimates the difference between the server's clock and the
+// local clock (i.e., the value to add to a local time to obtain the server's
+// time), from the server-time tags on PONG replies to recent keepalive PINGs.
+// As in NTP, the estimate is taken from the round trip with the lowest
+// latency, assuming the server processed the PING halfway through it.
+// ok is false if no estimate is available (e.g. if server-time was not
+// negotiated, or no keepalive has completed yet).
+func (irc *Connection) ClockOffset() (offset time.Duration, ok bool) {
+	irc.stateMutex.Lock()
+	defer irc.stateMutex.Unlock()
+	var bestRTT time.Duration
+	for _, sample := range irc.keepaliveSamples {
+		if sample.hasOffset && (!ok || sample.rtt < bestRTT) {
+			offset, bestRTT, ok = sample.offset, sample.rtt, true
+		}
+	}
+	return
+}
+
+// EventTime returns the time of an event, as measured by the server's clock:
+// this is the server-time tag if present (as on messages replayed by a
+// bouncer or from history), otherwise the time the line was received,
+// corrected by ClockOffset(). It should be called from a callback, while
+// the event is being processed.
+func (irc *Connection) EventTime(e ircmsg.Message) time.Time {
+	if serverTime, ok := e.ServerTime(); ok {
+		return serverTime
+	}
+	irc.stateMutex.Lock()
+	receivedAt := irc.lastReceivedAt
+	irc.stateMutex.Unlock()
+	if receivedAt.IsZero() {
+		receivedAt = time.Now()
+	}
+	if offset, ok := irc.ClockOffset(); ok {
+		receivedAt = receivedAt.Add(offset)
+	}
+	return receivedAt.UTC()
+}
+
+func (irc *Connection) setLastReceivedAt(t time.Time) {
+	irc.stateMutex.Lock()
+	defer irc.stateMutex.Unlock()
+	irc.lastReceivedAt = t
+}
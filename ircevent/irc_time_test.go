@@ -0,0 +1,72 @@
+package ircevent
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+func mockPong(pingSentAt time.Time, serverTime time.Time) ircmsg.Message {
+	param := fmt.Sprintf("%s%d", keepalivePrefix, pingSentAt.UnixNano())
+	if serverTime.IsZero() {
+		return mustParse(":irc.example.com PONG irc.example.com " + param)
+	}
+	return mustParse("@time=" + ircmsg.FormatServerTime(serverTime) + " :irc.example.com PONG irc.example.com " + param)
+}
+
+func assertDurationNear(found, expected, tolerance time.Duration) {
+	if found < expected-tolerance || expected+tolerance < found {
+		panic(fmt.Errorf("expected about `%v`, got `%v`", expected, found))
+	}
+}
+
+func TestClockOffset(t *testing.T) {
+	irc, _ := mockConnection()
+	_, ok := irc.ClockOffset()
+	assertEqual(ok, false)
+
+	// without server-time, there's no estimate:
+	irc.recordPong(mockPong(time.Now().Add(-50*time.Millisecond), time.Time{}))
+	_, ok = irc.ClockOffset()
+	assertEqual(ok, false)
+
+	// the server's clock is 10 seconds ahead, round trip was 200ms:
+	now := time.Now()
+	irc.recordPong(mockPong(now.Add(-200*time.Millisecond), now.Add(-100*time.Millisecond+10*time.Second)))
+	offset, ok := irc.ClockOffset()
+	assertEqual(ok, true)
+	assertDurationNear(offset, 10*time.Second, 20*time.Millisecond)
+
+	// a faster round trip is preferred, a slower one is ignored:
+	now = time.Now()
+	irc.recordPong(mockPong(now.Add(-20*time.Millisecond), now.Add(-10*time.Millisecond+9*time.Second)))
+	irc.recordPong(mockPong(now.Add(-time.Second), now.Add(-500*time.Millisecond+5*time.Second)))
+	offset, _ = irc.ClockOffset()
+	assertDurationNear(offset, 9*time.Second, 20*time.Millisecond)
+
+	// only recent samples are kept:
+	for i := 0; i < maxKeepaliveSamples; i++ {
+		now = time.Now()
+		irc.recordPong(mockPong(now.Add(-100*time.Millisecond), now.Add(-50*time.Millisecond-time.Second)))
+	}
+	assertEqual(len(irc.keepaliveSamples), maxKeepaliveSamples)
+	offset, _ = irc.ClockOffset()
+	assertDurationNear(offset, -time.Second, 20*time.Millisecond)
+}
+
+func TestEventTime(t *testing.T) {
+	irc, _ := mockConnection()
+	expected := time.Date(2011, 10, 19, 16, 40, 51, 620000000, time.UTC)
+	assertEqual(irc.EventTime(mustParse("@time=2011-10-19T16:40:51.620Z :alice!u@h PRIVMSG #chan :hi")), expected)
+
+	receivedAt := time.Now().Add(-time.Minute)
+	irc.setLastReceivedAt(receivedAt)
+	msg := mustParse(":alice!u@h PRIVMSG #chan :hi")
+	assertEqual(irc.EventTime(msg).Equal(receivedAt), true)
+
+	now := time.Now()
+	irc.recordPong(mockPong(now.Add(-10*time.Millisecond), now.Add(-5*time.Millisecond+time.Hour)))
+	assertDurationNear(irc.EventTime(msg).Sub(receivedAt), time.Hour, 20*time.Millisecond)
+}
@@ -0,0 +1,185 @@
+package ircfmt
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ANSIMode selects the color capabilities assumed by ToANSI.
+type ANSIMode uint
+
+const (
+	// ANSIColors16 uses only the 16 standard ANSI colors; IRC colors 16-98
+	// are approximated by the nearest of the 16 base IRC colors.
+	ANSIColors16 ANSIMode = iota
+	// ANSIColors256 renders IRC colors 16-98 with the xterm 256-color palette.
+	ANSIColors256
+	// ANSITrueColor renders IRC colors 16-98 with 24-bit RGB values.
+	ANSITrueColor
+)
+
+var (
+	// SGR foreground codes for the 16 base IRC colors
+	// (add 10 for the corresponding background codes):
+	ansiBaseColors = [16]int{97, 30, 34, 32, 91, 31, 35, 33, 93, 92, 36, 96, 94, 95, 90, 37}
+
+	// xterm 256-color approximations of IRC colors 16-98, as per
+	// https://modern.ircdocs.horse/formatting.html#colors-16-98
+	ansi256Colors = [83]int{
+		52, 94, 100, 58, 22, 29, 23, 24, 17, 54, 53, 89,
+		88, 130, 142, 64, 28, 35, 30, 25, 18, 91, 90, 125,
+		124, 166, 184, 106, 34, 49, 37, 33, 19, 129, 127, 161,
+		196, 208, 226, 154, 46, 86, 51, 75, 21, 171, 201, 198,
+		203, 215, 227, 191, 83, 122, 87, 111, 63, 177, 207, 205,
+		217, 223, 229, 193, 157, 158, 159, 153, 147, 183, 219, 212,
+		16, 233, 235, 237, 239, 241, 244, 247, 250, 254, 231,
+	}
+
+	// nearest base color (0-15) to each of the 99 IRC colors
+	nearestBaseColor [99]uint8
+)
+
+func init() {
+	for i, rgb := range colorPalette {
+		if i < 16 {
+			nearestBaseColor[i] = uint8(i)
+		} else {
+			nearestBaseColor[i] = nearestBaseColorRGB(rgb)
+		}
+	}
+}
+
+func nearestBaseColorRGB(rgb uint32) uint8 {
+	best, bestDistance := 0, -1
+	for j := 0; j < 16; j++ {
+		if d := rgbDistance(rgb, colorPalette[j]); bestDistance == -1 || d < bestDistance {
+			best, bestDistance = j, d
+		}
+	}
+	return uint8(best)
+}
+
+// xterm256Color approximates an RGB value with the 6x6x6 color cube
+// of the xterm 256-color palette (colors 16-231).
+func xterm256Color(rgb uint32) int {
+	level := func(c uint32) int {
+		// the cube's levels are 0, 95, 135, 175, 215 and 255
+		if c < 48 {
+			return 0
+		} else if c < 115 {
+			return 1
+		}
+		return int(c-35) / 40
+	}
+	return 16 + 36*level(rgb>>16&0xff) + 6*level(rgb>>8&0xff) + level(rgb&0xff)
+}
+
+func rgbDistance(a, b uint32) int {
+	dr := int(a>>16&0xff) - int(b>>16&0xff)
+	dg := int(a>>8&0xff) - int(b>>8&0xff)
+	db := int(a&0xff) - int(b&0xff)
+	return dr*dr + dg*dg + db*db
+}
+
+// ToANSI converts an IRC message containing formatting codes into text
+// containing ANSI SGR escape sequences, suitable for printing to a terminal.
+// The 16 base IRC colors always use the 16 standard ANSI colors; mode controls
+// the rendering of colors 16-98 and hex colors. Monospace has no ANSI equivalent and is
+// ignored. If the output contains any formatting, it ends with a reset.
+// Other control characters (including any ANSI escape sequences contained
+// in the message) are removed, except for tabs.
+func ToANSI(raw string, mode ANSIMode) string {
+	var out strings.Builder
+	var current FormattedSubstring
+	for _, chunk := range Split(raw) {
+		content := chunk.Content
+		chunk.Content = ""
+		chunk.Monospace = false
+		if chunk != current {
+			writeSGR(&out, &chunk, mode)
+			current = chunk
+		}
+		writeTerminalSafe(&out, content)
+	}
+	if current.IsFormatted() {
+		out.WriteString("\x1b[0m")
+	}
+	return out.String()
+}
+
+func writeSGR(out *strings.Builder, chunk *FormattedSubstring, mode ANSIMode) {
+	// always reset first, so that attributes that were switched off are cleared
+	out.WriteString("\x1b[0")
+	if chunk.Bold {
+		out.WriteString(";1")
+	}
+	if chunk.Italic {
+		out.WriteString(";3")
+	}
+	if chunk.Underline {
+		out.WriteString(";4")
+	}
+	if chunk.ReverseColor {
+		out.WriteString(";7")
+	}
+	if chunk.Strikethrough {
+		out.WriteString(";9")
+	}
+	fg, bg := chunk.colors()
+	writeSGRColor(out, fg, false, mode)
+	writeSGRColor(out, bg, true, mode)
+	out.WriteByte('m')
+}
+
+func writeSGRColor(out *strings.Builder, color colorValue, background bool, mode ANSIMode) {
+	rgb, ok := color.ToRGB()
+	if !ok {
+		return
+	}
+	out.WriteByte(';')
+	if (!color.IsRGB && color.Value < 16) || mode == ANSIColors16 {
+		var base uint8
+		if color.IsRGB {
+			base = nearestBaseColorRGB(rgb)
+		} else {
+			base = nearestBaseColor[color.Value]
+		}
+		code := ansiBaseColors[base]
+		if background {
+			code += 10
+		}
+		out.WriteString(strconv.Itoa(code))
+		return
+	}
+	if background {
+		out.WriteString("48;")
+	} else {
+		out.WriteString("38;")
+	}
+	if mode == ANSIColors256 {
+		out.WriteString("5;")
+		if color.IsRGB {
+			out.WriteString(strconv.Itoa(xterm256Color(rgb)))
+		} else {
+			out.WriteString(strconv.Itoa(ansi256Colors[color.Value-16]))
+		}
+	} else {
+		out.WriteString("2;")
+		out.WriteString(strconv.Itoa(int(rgb >> 16 & 0xff)))
+		out.WriteByte(';')
+		out.WriteString(strconv.Itoa(int(rgb >> 8 & 0xff)))
+		out.WriteByte(';')
+		out.WriteString(strconv.Itoa(int(rgb & 0xff)))
+	}
+}
+
+// writeTerminalSafe writes content, removing C0 and C1 control characters
+// (other than tab) that could be interpreted by the terminal.
+func writeTerminalSafe(out *strings.Builder, content string) {
+	for _, r := range content {
+		if (r < 0x20 && r != '\t') || (0x7f <= r && r <= 0x9f) {
+			continue
+		}
+		out.WriteRune(r)
+	}
+}
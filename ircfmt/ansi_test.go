@@ -0,0 +1,42 @@
+package ircfmt
+
+import (
+	"testing"
+)
+
+type ansiTestCase struct {
+	input  string
+	mode   ANSIMode
+	output string
+}
+
+var ansiTestCases = []ansiTestCase{
+	{"", ANSIColors16, ""},
+	{"plain text", ANSIColors16, "plain text"},
+	{"a \x02bold\x02 word", ANSIColors16, "a \x1b[0;1mbold\x1b[0m word"},
+	{"\x02\x1dbold italic\x0f", ANSIColors16, "\x1b[0;1;3mbold italic\x1b[0m"},
+	{"\x1f\x1e\x16all", ANSIColors16, "\x1b[0;4;7;9mall\x1b[0m"},
+	{"\x02\x02no-op", ANSIColors16, "no-op"},
+	{"\x11monospace", ANSIColors16, "monospace"},
+	{"\x034,12red on blue\x03 done", ANSIColors16, "\x1b[0;91;104mred on blue\x1b[0m done"},
+	{"\x030white\x031black\x0315grey", ANSIColors256, "\x1b[0;97mwhite\x1b[0;30mblack\x1b[0;37mgrey\x1b[0m"},
+	{"\x0352red", ANSIColors16, "\x1b[0;91mred\x1b[0m"},
+	{"\x0352red", ANSIColors256, "\x1b[0;38;5;196mred\x1b[0m"},
+	{"\x0352red", ANSITrueColor, "\x1b[0;38;2;255;0;0mred\x1b[0m"},
+	{"\x0301,89dark", ANSIColors256, "\x1b[0;30;48;5;233mdark\x1b[0m"},
+	{"\x0301,89dark", ANSITrueColor, "\x1b[0;30;48;2;19;19;19mdark\x1b[0m"},
+	{"\x0399,98x", ANSIColors16, "\x1b[0;107mx\x1b[0m"},
+	{"no \x1b[2Jescape\ttab\u009b", ANSIColors16, "no [2Jescape\ttab"},
+	{"\x04ff8000hex", ANSIColors16, "\x1b[0;33mhex\x1b[0m"},
+	{"\x04ff8000hex", ANSIColors256, "\x1b[0;38;5;208mhex\x1b[0m"},
+	{"\x04ff8000,123456hex", ANSITrueColor, "\x1b[0;38;2;255;128;0;48;2;18;52;86mhex\x1b[0m"},
+}
+
+func TestToANSI(t *testing.T) {
+	for i, testCase := range ansiTestCases {
+		actual := ToANSI(testCase.input, testCase.mode)
+		if actual != testCase.output {
+			t.Errorf("Test case %d failed: expected %q, got %q", i, testCase.output, actual)
+		}
+	}
+}
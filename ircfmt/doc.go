@@ -22,6 +22,7 @@ with the given escape characters:
 	 Dollarsign     |   $$   |  $
 	 Bold           |   $b   | 0x02
 	 Colour         |   $c   | 0x03
+	 Hex Colour     |   $h   | 0x04
 	 Monospace      |   $m   | 0x11
 	 Reverse Colour |   $v   | 0x16
 	 Italic         |   $i   | 0x1d
@@ -82,5 +83,15 @@ Here are the colour names and codes we recognise:
 
 These other colours aren't given names:
 https://modern.ircdocs.horse/formatting.html#colors-16-98
+
+Hex colours (0x04 followed by six hex digits for the foreground, and optionally
+a comma and six more for the background) are escaped as "$h" followed by the
+raw digits. When unescaping, they can also be given in square brackets after
+the colour escape, prefixed with "#"; colour names and codes given alongside
+them are converted to hex:
+
+	Hex foreground, blue background:
+		Escaped:  This is a $c[#ff8000,blue]hip message!
+		Raw:      This is a 0x04ff8000,00007fhip message!
 */
 package ircfmt
@@ -0,0 +1,219 @@
+package ircfmt
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+const (
+	defaultHTMLClassPrefix = "irc-"
+)
+
+// HTMLOptions controls the output of ToHTML. The zero value renders
+// formatting with inline styles and does not linkify URLs.
+type HTMLOptions struct {
+	// UseClasses renders formatting with CSS classes (e.g. "irc-bold irc-fg4")
+	// instead of inline styles; see HTMLStylesheet for a matching stylesheet.
+	UseClasses bool
+	// ClassPrefix is prepended to every class name (default "irc-");
+	// it is escaped for use in the class attribute.
+	ClassPrefix string
+	// Linkify wraps http, https, irc and ircs URLs in <a> elements.
+	Linkify bool
+}
+
+var (
+	urlRe = regexp.MustCompile(`(?i)\b(?:https?|ircs?)://[^\s<>"\x00-\x1f\x7f]+`)
+)
+
+// ToHTML converts an IRC message containing formatting codes into HTML,
+// escaping the content so that it is safe to embed in a document.
+// Formatted sections are wrapped in <span> elements. Reversed text swaps
+// the foreground and background colors; with inline styles, a missing color
+// is assumed to be black (for the foreground) or white (for the background)
+// before the swap, and with classes, the reverse class is added so that the
+// stylesheet can supply theme-appropriate defaults.
+func ToHTML(raw string, options HTMLOptions) string {
+	var out strings.Builder
+	for _, chunk := range Split(raw) {
+		if !chunk.IsFormatted() {
+			writeHTMLContent(&out, chunk.Content, options.Linkify)
+			continue
+		}
+		out.WriteString(`<span`)
+		if options.UseClasses {
+			if classes := htmlClasses(&chunk, options.ClassPrefix); classes != "" {
+				out.WriteString(` class="`)
+				out.WriteString(html.EscapeString(classes))
+				out.WriteString(`"`)
+			}
+			// hex colors can't be represented by classes:
+			if style := htmlHexColorStyle(&chunk); style != "" {
+				out.WriteString(` style="`)
+				out.WriteString(style)
+				out.WriteString(`"`)
+			}
+		} else {
+			out.WriteString(` style="`)
+			out.WriteString(htmlStyle(&chunk))
+			out.WriteString(`"`)
+		}
+		out.WriteString(`>`)
+		writeHTMLContent(&out, chunk.Content, options.Linkify)
+		out.WriteString(`</span>`)
+	}
+	return out.String()
+}
+
+func writeHTMLContent(out *strings.Builder, content string, linkify bool) {
+	if !linkify {
+		out.WriteString(html.EscapeString(content))
+		return
+	}
+	for {
+		loc := urlRe.FindStringIndex(content)
+		if loc == nil {
+			out.WriteString(html.EscapeString(content))
+			return
+		}
+		url := trimURL(content[loc[0]:loc[1]])
+		escaped := html.EscapeString(url)
+		out.WriteString(html.EscapeString(content[:loc[0]]))
+		fmt.Fprintf(out, `<a href="%s" rel="nofollow noopener noreferrer">%s</a>`, escaped, escaped)
+		content = content[loc[0]+len(url):]
+	}
+}
+
+// trimURL removes trailing punctuation that is more likely to belong to the
+// surrounding sentence than to the URL, e.g. "(see https://example.com/)."
+func trimURL(url string) string {
+	for len(url) != 0 {
+		last := url[len(url)-1]
+		if strings.IndexByte(".,:;!?'*", last) != -1 {
+			url = url[:len(url)-1]
+		} else if last == ')' && strings.Count(url, ")") > strings.Count(url, "(") {
+			url = url[:len(url)-1]
+		} else {
+			break
+		}
+	}
+	return url
+}
+
+// effectiveColors returns the colors to display, taking reverse into account.
+func effectiveColors(chunk *FormattedSubstring, defaultFg, defaultBg colorValue) (fg, bg colorValue) {
+	fg, bg = chunk.colors()
+	if chunk.ReverseColor {
+		if !fg.IsSet {
+			fg = defaultFg
+		}
+		if !bg.IsSet {
+			bg = defaultBg
+		}
+		fg, bg = bg, fg
+	}
+	return
+}
+
+func htmlStyle(chunk *FormattedSubstring) string {
+	var styles []string
+	fg, bg := effectiveColors(chunk, colorValue{IsSet: true, Value: 1}, colorValue{IsSet: true, Value: 0})
+	if rgb, ok := fg.ToRGB(); ok {
+		styles = append(styles, fmt.Sprintf("color: #%06x", rgb))
+	}
+	if rgb, ok := bg.ToRGB(); ok {
+		styles = append(styles, fmt.Sprintf("background-color: #%06x", rgb))
+	}
+	if chunk.Bold {
+		styles = append(styles, "font-weight: bold")
+	}
+	if chunk.Italic {
+		styles = append(styles, "font-style: italic")
+	}
+	if chunk.Monospace {
+		styles = append(styles, "font-family: monospace")
+	}
+	if chunk.Underline && chunk.Strikethrough {
+		styles = append(styles, "text-decoration: underline line-through")
+	} else if chunk.Underline {
+		styles = append(styles, "text-decoration: underline")
+	} else if chunk.Strikethrough {
+		styles = append(styles, "text-decoration: line-through")
+	}
+	return strings.Join(styles, "; ")
+}
+
+func htmlClasses(chunk *FormattedSubstring, prefix string) string {
+	if prefix == "" {
+		prefix = defaultHTMLClassPrefix
+	}
+	var classes []string
+	addClass := func(name string) {
+		classes = append(classes, prefix+name)
+	}
+	fg, bg := effectiveColors(chunk, colorValue{}, colorValue{})
+	if fg.IsSet && !fg.IsRGB {
+		addClass(fmt.Sprintf("fg%d", fg.Value))
+	}
+	if bg.IsSet && !bg.IsRGB {
+		addClass(fmt.Sprintf("bg%d", bg.Value))
+	}
+	if chunk.Bold {
+		addClass("bold")
+	}
+	if chunk.Italic {
+		addClass("italic")
+	}
+	if chunk.Monospace {
+		addClass("monospace")
+	}
+	if chunk.Underline {
+		addClass("underline")
+	}
+	if chunk.Strikethrough {
+		addClass("strikethrough")
+	}
+	if chunk.ReverseColor {
+		addClass("reverse")
+	}
+	return strings.Join(classes, " ")
+}
+
+func htmlHexColorStyle(chunk *FormattedSubstring) string {
+	var styles []string
+	fg, bg := effectiveColors(chunk, colorValue{}, colorValue{})
+	if fg.IsRGB {
+		styles = append(styles, fmt.Sprintf("color: #%06x", fg.RGB))
+	}
+	if bg.IsRGB {
+		styles = append(styles, fmt.Sprintf("background-color: #%06x", bg.RGB))
+	}
+	return strings.Join(styles, "; ")
+}
+
+// HTMLStylesheet returns a CSS stylesheet defining the classes used by
+// ToHTML when UseClasses is set, for the given class prefix (default "irc-").
+// The reverse class renders white on black; themes can override it by
+// defining it again before the color classes.
+func HTMLStylesheet(prefix string) string {
+	if prefix == "" {
+		prefix = defaultHTMLClassPrefix
+	}
+	var out strings.Builder
+	fmt.Fprintf(&out, ".%sbold { font-weight: bold; }\n", prefix)
+	fmt.Fprintf(&out, ".%sitalic { font-style: italic; }\n", prefix)
+	fmt.Fprintf(&out, ".%smonospace { font-family: monospace; }\n", prefix)
+	fmt.Fprintf(&out, ".%sunderline { text-decoration: underline; }\n", prefix)
+	fmt.Fprintf(&out, ".%sstrikethrough { text-decoration: line-through; }\n", prefix)
+	fmt.Fprintf(&out, ".%sunderline.%sstrikethrough { text-decoration: underline line-through; }\n", prefix, prefix)
+	fmt.Fprintf(&out, ".%sreverse { color: #ffffff; background-color: #000000; }\n", prefix)
+	for i, rgb := range colorPalette {
+		fmt.Fprintf(&out, ".%sfg%d { color: #%06x; }\n", prefix, i, rgb)
+	}
+	for i, rgb := range colorPalette {
+		fmt.Fprintf(&out, ".%sbg%d { background-color: #%06x; }\n", prefix, i, rgb)
+	}
+	return out.String()
+}
@@ -0,0 +1,62 @@
+package ircfmt
+
+import (
+	"strings"
+	"testing"
+)
+
+type htmlTestCase struct {
+	input   string
+	options HTMLOptions
+	output  string
+}
+
+var htmlTestCases = []htmlTestCase{
+	{"", HTMLOptions{}, ""},
+	{"plain <b>text</b> & \"quotes\"", HTMLOptions{}, "plain &lt;b&gt;text&lt;/b&gt; &amp; &#34;quotes&#34;"},
+	{"a \x02bold\x02 word", HTMLOptions{}, `a <span style="font-weight: bold">bold</span> word`},
+	{"\x1d\x1f\x1eall\x11mono", HTMLOptions{},
+		`<span style="font-style: italic; text-decoration: underline line-through">all</span>` +
+			`<span style="font-style: italic; font-family: monospace; text-decoration: underline line-through">mono</span>`},
+	{"\x034,12red on blue\x03 done", HTMLOptions{}, `<span style="color: #ff0000; background-color: #0000fc">red on blue</span> done`},
+	{"\x0352bright\x0398grey", HTMLOptions{}, `<span style="color: #ff0000">bright</span><span style="color: #ffffff">grey</span>`},
+	{"\x16reversed\x0f", HTMLOptions{}, `<span style="color: #ffffff; background-color: #000000">reversed</span>`},
+	{"\x034\x16reversed", HTMLOptions{}, `<span style="color: #ffffff; background-color: #ff0000">reversed</span>`},
+	{"\x02\x034,12<x>", HTMLOptions{UseClasses: true}, `<span class="irc-fg4 irc-bg12 irc-bold">&lt;x&gt;</span>`},
+	{"\x034\x16\x1fx", HTMLOptions{UseClasses: true, ClassPrefix: "f-"}, `<span class="f-bg4 f-underline f-reverse">x</span>`},
+	{"see https://example.com/a?b=1&c=2.", HTMLOptions{Linkify: true},
+		`see <a href="https://example.com/a?b=1&amp;c=2" rel="nofollow noopener noreferrer">https://example.com/a?b=1&amp;c=2</a>.`},
+	{"(http://en.wikipedia.org/wiki/Foo_(bar)) and \x02ircs://irc.example.com/#chat\x02", HTMLOptions{Linkify: true},
+		`(<a href="http://en.wikipedia.org/wiki/Foo_(bar)" rel="nofollow noopener noreferrer">http://en.wikipedia.org/wiki/Foo_(bar)</a>) and ` +
+			`<span style="font-weight: bold"><a href="ircs://irc.example.com/#chat" rel="nofollow noopener noreferrer">ircs://irc.example.com/#chat</a></span>`},
+	{"javascript:alert(1) <https://x.y/\"onmouseover=\"z>", HTMLOptions{Linkify: true},
+		`javascript:alert(1) &lt;<a href="https://x.y/" rel="nofollow noopener noreferrer">https://x.y/</a>&#34;onmouseover=&#34;z&gt;`},
+	{"https://example.com", HTMLOptions{}, "https://example.com"},
+	{"\x04ff8000,000000hex", HTMLOptions{}, `<span style="color: #ff8000; background-color: #000000">hex</span>`},
+	{"\x02\x04ff8000\x0304hex", HTMLOptions{UseClasses: true}, `<span class="irc-fg4 irc-bold">hex</span>`},
+	{"\x02\x04ff8000,000000hex", HTMLOptions{UseClasses: true}, `<span class="irc-bold" style="color: #ff8000; background-color: #000000">hex</span>`},
+	{"\x04ff8000,000000hex", HTMLOptions{UseClasses: true}, `<span style="color: #ff8000; background-color: #000000">hex</span>`},
+	{"\x02x", HTMLOptions{UseClasses: true, ClassPrefix: `"><script>`}, `<span class="&#34;&gt;&lt;script&gt;bold">x</span>`},
+}
+
+func TestToHTML(t *testing.T) {
+	for i, testCase := range htmlTestCases {
+		actual := ToHTML(testCase.input, testCase.options)
+		if actual != testCase.output {
+			t.Errorf("Test case %d failed: expected %q, got %q", i, testCase.output, actual)
+		}
+	}
+}
+
+func TestHTMLStylesheet(t *testing.T) {
+	css := HTMLStylesheet("")
+	for _, rule := range []string{".irc-bold {", ".irc-fg0 { color: #ffffff; }", ".irc-bg98 { background-color: #ffffff; }", ".irc-fg52 { color: #ff0000; }"} {
+		if !strings.Contains(css, rule) {
+			t.Errorf("stylesheet is missing %q", rule)
+		}
+	}
+	// the reverse defaults must precede (and be overridden by) the color classes:
+	if strings.Index(css, ".irc-reverse") > strings.Index(css, ".irc-fg0") {
+		t.Error("reverse class must precede the color classes")
+	}
+}
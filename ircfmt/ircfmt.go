@@ -4,6 +4,7 @@
 package ircfmt
 
 import (
+	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
@@ -13,6 +14,7 @@ const (
 	// raw bytes and strings to do replacing with
 	bold          string = "\x02"
 	colour        string = "\x03"
+	hexColour     string = "\x04"
 	monospace     string = "\x11"
 	reverseColour string = "\x16"
 	italic        string = "\x1d"
@@ -20,20 +22,77 @@ const (
 	underline     string = "\x1f"
 	reset         string = "\x0f"
 
-	metacharacters = (bold + colour + monospace + reverseColour + italic + strikethrough + underline + reset)
+	metacharacters = (bold + colour + hexColour + monospace + reverseColour + italic + strikethrough + underline + reset)
 )
 
 // ColorCode is a normalized representation of an IRC color code,
 // as per this de facto specification: https://modern.ircdocs.horse/formatting.html#color
 // The zero value of the type represents a default or unset color,
-// whereas ColorCode{true, 0} represents the color white.
+// whereas ColorCode{IsSet: true, Value: 0} represents the color white.
+// Colors set with the hex color code (\x04) are represented by HexColor.
 type ColorCode struct {
 	IsSet bool
 	Value uint8
 }
 
+// ToRGB returns the RGB value (0xRRGGBB) of a color, using the conventional
+// palette for the 99 IRC color codes, or false if the color is unset.
+func (c ColorCode) ToRGB() (rgb uint32, ok bool) {
+	if !c.IsSet {
+		return 0, false
+	}
+	return ColorRGB(c.Value)
+}
+
+// HexColor is a color set with the hex color code (\x04), as per
+// https://modern.ircdocs.horse/formatting.html#hex-color
+// The zero value represents an unset color.
+type HexColor struct {
+	IsSet bool
+	RGB   uint32 // 0xRRGGBB
+}
+
+// colorValue is either a ColorCode or a HexColor (if IsRGB is set),
+// for code that handles both kinds of color alike.
+type colorValue struct {
+	IsSet bool
+	Value uint8
+	IsRGB bool
+	RGB   uint32
+}
+
+func (c colorValue) ToRGB() (rgb uint32, ok bool) {
+	if c.IsRGB {
+		return c.RGB, c.IsSet
+	}
+	return ColorCode{IsSet: c.IsSet, Value: c.Value}.ToRGB()
+}
+
+func makeColorValue(color ColorCode, hexColor HexColor) colorValue {
+	if hexColor.IsSet {
+		return colorValue{IsSet: true, IsRGB: true, RGB: hexColor.RGB}
+	}
+	return colorValue{IsSet: color.IsSet, Value: color.Value}
+}
+
+// colors returns the foreground and background colors of a chunk,
+// of either kind.
+func (f *FormattedSubstring) colors() (fg, bg colorValue) {
+	return makeColorValue(f.ForegroundColor, f.ForegroundHexColor), makeColorValue(f.BackgroundColor, f.BackgroundHexColor)
+}
+
+// ColorRGB returns the RGB value (0xRRGGBB) conventionally used to display
+// one of the 99 IRC color codes, as per
+// https://modern.ircdocs.horse/formatting.html#colors-16-98
+func ColorRGB(value uint8) (rgb uint32, ok bool) {
+	if int(value) < len(colorPalette) {
+		return colorPalette[value], true
+	}
+	return 0, false
+}
+
 // ParseColor converts a string representation of an IRC color code, e.g. "04",
-// into a normalized ColorCode, e.g. ColorCode{true, 4}.
+// into a normalized ColorCode, e.g. ColorCode{IsSet: true, Value: 4}.
 func ParseColor(str string) (color ColorCode) {
 	// "99 - Default Foreground/Background - Not universally supported."
 	// normalize 99 to ColorCode{} meaning "unset":
@@ -44,6 +103,19 @@ func ParseColor(str string) (color ColorCode) {
 	return
 }
 
+// ParseHexColor converts the string representation of a hex color,
+// e.g. "FF0000", into a HexColor, e.g. HexColor{IsSet: true, RGB: 0xff0000}.
+func ParseHexColor(str string) (color HexColor) {
+	if len(str) != 6 {
+		return
+	}
+	if rgb, err := strconv.ParseUint(str, 16, 32); err == nil {
+		color.IsSet = true
+		color.RGB = uint32(rgb)
+	}
+	return
+}
+
 // FormattedSubstring represents a section of an IRC message with associated
 // formatting data.
 type FormattedSubstring struct {
@@ -56,6 +128,10 @@ type FormattedSubstring struct {
 	Underline       bool
 	Italic          bool
 	ReverseColor    bool
+	// colors set with the hex color code (\x04); if set, these take
+	// the place of ForegroundColor and BackgroundColor respectively:
+	ForegroundHexColor HexColor
+	BackgroundHexColor HexColor
 }
 
 // IsFormatted returns whether the section has any formatting flags switched on.
@@ -75,6 +151,10 @@ var (
 	colorForeBackRe = regexp.MustCompile(`^([0-9]{1,2}),([0-9]{1,2})`)
 	// (\x03)00
 	colorForeRe = regexp.MustCompile(`^([0-9]{1,2})`)
+	// (\x04)FF0000,00FF00
+	hexColorForeBackRe = regexp.MustCompile(`^([0-9a-fA-F]{6}),([0-9a-fA-F]{6})`)
+	// (\x04)FF0000
+	hexColorForeRe = regexp.MustCompile(`^([0-9a-fA-F]{6})`)
 )
 
 // Split takes an IRC message (typically a PRIVMSG or NOTICE final parameter)
@@ -120,16 +200,30 @@ func Split(raw string) (result []FormattedSubstring) {
 		case colour[0]:
 			// preferentially match the "\x0399,01" form, then "\x0399";
 			// if neither of those matches, then it's a reset
+			// (a color of either kind replaces one of the other kind)
 			if matches := colorForeBackRe.FindStringSubmatch(raw); len(matches) != 0 {
-				chunk.ForegroundColor = ParseColor(matches[1])
-				chunk.BackgroundColor = ParseColor(matches[2])
+				chunk.ForegroundColor, chunk.ForegroundHexColor = ParseColor(matches[1]), HexColor{}
+				chunk.BackgroundColor, chunk.BackgroundHexColor = ParseColor(matches[2]), HexColor{}
 				raw = raw[len(matches[0]):]
 			} else if matches := colorForeRe.FindStringSubmatch(raw); len(matches) != 0 {
-				chunk.ForegroundColor = ParseColor(matches[1])
+				chunk.ForegroundColor, chunk.ForegroundHexColor = ParseColor(matches[1]), HexColor{}
 				raw = raw[len(matches[0]):]
 			} else {
-				chunk.ForegroundColor = ColorCode{}
-				chunk.BackgroundColor = ColorCode{}
+				chunk.ForegroundColor, chunk.ForegroundHexColor = ColorCode{}, HexColor{}
+				chunk.BackgroundColor, chunk.BackgroundHexColor = ColorCode{}, HexColor{}
+			}
+		case hexColour[0]:
+			// as above, but with six hex digits for each color
+			if matches := hexColorForeBackRe.FindStringSubmatch(raw); len(matches) != 0 {
+				chunk.ForegroundColor, chunk.ForegroundHexColor = ColorCode{}, ParseHexColor(matches[1])
+				chunk.BackgroundColor, chunk.BackgroundHexColor = ColorCode{}, ParseHexColor(matches[2])
+				raw = raw[len(matches[0]):]
+			} else if matches := hexColorForeRe.FindStringSubmatch(raw); len(matches) != 0 {
+				chunk.ForegroundColor, chunk.ForegroundHexColor = ColorCode{}, ParseHexColor(matches[1])
+				raw = raw[len(matches[0]):]
+			} else {
+				chunk.ForegroundColor, chunk.ForegroundHexColor = ColorCode{}, HexColor{}
+				chunk.BackgroundColor, chunk.BackgroundHexColor = ColorCode{}, HexColor{}
 			}
 		default:
 			// should be impossible, but just ignore it
@@ -139,12 +233,13 @@ func Split(raw string) (result []FormattedSubstring) {
 
 var (
 	// valtoescape replaces most of IRC characters with our escapes.
-	valtoescape = strings.NewReplacer("$", "$$", colour, "$c", reverseColour, "$v", bold, "$b", italic, "$i", strikethrough, "$s", underline, "$u", monospace, "$m", reset, "$r")
+	valtoescape = strings.NewReplacer("$", "$$", colour, "$c", hexColour, "$h", reverseColour, "$v", bold, "$b", italic, "$i", strikethrough, "$s", underline, "$u", monospace, "$m", reset, "$r")
 
 	// escapetoval contains most of our escapes and how they map to real IRC characters.
 	// intentionally skips colour, since that's handled elsewhere.
 	escapetoval = map[rune]string{
 		'$': "$",
+		'h': hexColour,
 		'b': bold,
 		'i': italic,
 		'v': reverseColour,
@@ -207,8 +302,9 @@ var (
 		"default":     "99",
 	}
 
-	bracketedExpr = regexp.MustCompile(`^\[.*?\]`)
-	colourDigits  = regexp.MustCompile(`^[0-9]{1,2}$`)
+	bracketedExpr   = regexp.MustCompile(`^\[.*?\]`)
+	colourDigits    = regexp.MustCompile(`^[0-9]{1,2}$`)
+	hexColourDigits = regexp.MustCompile(`^#[0-9a-f]{6}$`)
 )
 
 // Escape takes a raw IRC string and returns it with our escapes.
@@ -243,7 +339,7 @@ func Escape(in string) string {
 			if 1 < len(inRunes) && inRunes[0] == ',' && isDigit(inRunes[1]) {
 				backBuffer += string(inRunes[1])
 				inRunes = inRunes[2:]
-				if 0 < len(inRunes) && isDigit(inRunes[1]) {
+				if 0 < len(inRunes) && isDigit(inRunes[0]) {
 					backBuffer += string(inRunes[0])
 					inRunes = inRunes[1:]
 				}
@@ -306,15 +402,28 @@ func Strip(in string) string {
 	}
 }
 
-// resolve "light blue" to "12", "12" to "12", "asdf" to "", etc.
+// resolve "light blue" to "12", "12" to "12", "#FF0000" to "#ff0000", "asdf" to "", etc.
 func resolveToColourCode(str string) (result string) {
 	str = strings.ToLower(strings.TrimSpace(str))
-	if colourDigits.MatchString(str) {
+	if colourDigits.MatchString(str) || hexColourDigits.MatchString(str) {
 		return str
 	}
 	return colourcodesTruncated[str]
 }
 
+// resolve "#ff0000" to "ff0000", "12" to "0000fc", "99" to "", etc.
+func resolveToHexColourCode(code string) (result string) {
+	if strings.HasPrefix(code, "#") {
+		return code[1:]
+	}
+	if value, err := strconv.ParseUint(code, 10, 8); err == nil {
+		if rgb, ok := ColorRGB(uint8(value)); ok {
+			return fmt.Sprintf("%06x", rgb)
+		}
+	}
+	return ""
+}
+
 // resolve "[light blue, black]" to ("13, "1")
 func resolveToColourCodes(namedColors string) (foreground, background string) {
 	// cut off the brackets
@@ -356,12 +465,11 @@ func Unescape(in string) string {
 		remaining = remaining[1:]
 
 		if char == 'c' {
-			out.WriteString(colour)
-
 			namedColors := bracketedExpr.FindString(remaining)
 			if namedColors == "" {
 				// for a non-bracketed color code, output the following characters directly,
 				// e.g., `$c1,8` will become `\x031,8`
+				out.WriteString(colour)
 				continue
 			}
 			// process bracketed color codes:
@@ -369,6 +477,31 @@ func Unescape(in string) string {
 			followedByDigit := len(remaining) != 0 && ('0' <= remaining[0] && remaining[0] <= '9')
 
 			foreground, background := resolveToColourCodes(namedColors)
+			if strings.HasPrefix(foreground, "#") || strings.HasPrefix(background, "#") {
+				// e.g. `$c[#ff0000,blue]` will become `\x04ff0000,00007f`
+				out.WriteString(hexColour)
+				foreground, background = resolveToHexColourCode(foreground), resolveToHexColourCode(background)
+				if foreground == "" && background != "" {
+					// the background can only be set along with the foreground,
+					// so use the background as a placeholder, then reset the
+					// foreground to the default, e.g. `$c[,#000000]` will become
+					// `\x04000000,000000\x0399`
+					out.WriteString(background + "," + background + colour + "99")
+				} else if foreground != "" {
+					out.WriteString(foreground)
+					if background != "" {
+						out.WriteByte(',')
+						out.WriteString(background)
+					}
+				}
+				continue
+			}
+
+			out.WriteString(colour)
+			if foreground == "" && background != "" {
+				// as above, 99 is the default foreground color
+				foreground = "99"
+			}
 			if foreground != "" {
 				if len(foreground) == 1 && background == "" && followedByDigit {
 					out.WriteByte('0')
@@ -20,11 +20,15 @@ var tests = []testcase{
 	{"test $$c", "test $c"},
 	{"test $c[]", "test \x03"},
 	{"test $$", "test $"},
+	{"te$hFF8000st", "te\x04FF8000st"},
+	{"te$hff8000,00007fst", "te\x04ff8000,00007fst"},
+	{"test $$h", "test $h"},
 }
 
 var escapetests = []testcase{
 	{"te$c[]st", "te\x03st"},
 	{"test$c[]", "test\x03"},
+	{"te$c[red,light blue]", "te\x034,12"},
 }
 
 var unescapetests = []testcase{
@@ -40,6 +44,12 @@ var unescapetests = []testcase{
 	{"te$c[4]1st", "te\x03041st"},
 	{"te$c[4,3]9st", "te\x034,039st"},
 	{"te$c[04,03]9st", "te\x0304,039st"},
+	{"te$c[#FF8000]st", "te\x04ff8000st"},
+	{"te$c[#ff8000,blue]st", "te\x04ff8000,00007fst"},
+	{"te$c[52,#000000]st", "te\x04ff0000,000000st"},
+	{"te$c[,#000000]st", "te\x04000000,000000\x0399st"},
+	{"te$c[,blue]st", "te\x0399,2st"},
+	{"te$c[,blue]9st", "te\x0399,029st"},
 	{"te$c[asdf   !23a fd4*#]st", "te\x03st"},
 	{"te$c[asdf  , !2,3a fd4*#]st", "te\x03st"},
 	{"Client opered up $c[grey][$r%s$c[grey], $r%s$c[grey]]", "Client opered up \x0314[\x0f%s\x0314, \x0f%s\x0314]"},
@@ -76,6 +86,11 @@ var stripTests = []testcase{
 	{"\x03\x03\x03\x03\x03\x03\x03\x03", ""},
 	{"\x03,\x031\x0312\x0334,\x0356,\x0378,90\x031234", ",,,34"},
 	{"\x0312,12\x03121212\x0311,333\x03,3\x038\x0399\x0355\x03test", "12123,3test"},
+	{"te\x04ff8000st", "test"},
+	{"te\x04FF8000,000000st", "test"},
+	{"te\x04ff80st", "teff80st"},
+	{"te\x04ff8000,00st", "te,00st"},
+	{"\x04", ""},
 }
 
 type splitTestCase struct {
@@ -145,6 +160,14 @@ var splitTestCases = []splitTestCase{
 	{"\x0311,13ab", []FormattedSubstring{
 		{Content: "ab", ForegroundColor: ColorCode{true, 11}, BackgroundColor: ColorCode{true, 13}},
 	}},
+	{"\x04FF8000a\x04b", []FormattedSubstring{
+		{Content: "a", ForegroundHexColor: HexColor{true, 0xff8000}},
+		{Content: "b"},
+	}},
+	{"\x0304,05\x04ff8000,00007fa\x03b", []FormattedSubstring{
+		{Content: "a", ForegroundHexColor: HexColor{true, 0xff8000}, BackgroundHexColor: HexColor{true, 0x00007f}},
+		{Content: "b"},
+	}},
 	{"\x0399,04the quick \t brown fox", []FormattedSubstring{
 		{Content: "the quick \t brown fox", BackgroundColor: ColorCode{true, 4}},
 	}},
@@ -219,6 +242,22 @@ func TestUnescape(t *testing.T) {
 	}
 }
 
+func TestUnescapeBackgroundOnly(t *testing.T) {
+	// the background survives, with the default foreground:
+	for escaped, expected := range map[string]FormattedSubstring{
+		"$c[,#000000]x": {Content: "x", BackgroundHexColor: HexColor{IsSet: true, RGB: 0}},
+		"$c[,blue]x":    {Content: "x", BackgroundColor: ColorCode{IsSet: true, Value: 2}},
+	} {
+		raw := Unescape(escaped)
+		if chunks := Split(raw); !reflect.DeepEqual(chunks, []FormattedSubstring{expected}) {
+			t.Errorf("For %q expected %#v got %#v", escaped, expected, chunks)
+		}
+		if again := Unescape(Escape(raw)); again != raw {
+			t.Errorf("For %q expected %q got %q", escaped, raw, again)
+		}
+	}
+}
+
 func TestStrip(t *testing.T) {
 	for _, pair := range stripTests {
 		val := Strip(pair.escaped)
@@ -231,3 +270,33 @@ func TestStrip(t *testing.T) {
 		}
 	}
 }
+
+func TestColorRGB(t *testing.T) {
+	for _, pair := range []struct {
+		color ColorCode
+		rgb   uint32
+		ok    bool
+	}{
+		{ColorCode{}, 0, false},
+		{ParseColor("04"), 0xff0000, true},
+		{ParseColor("99"), 0, false},
+		{ParseColor("98"), 0xffffff, true},
+		{ColorCode{IsSet: true, Value: 99}, 0, false},
+	} {
+		rgb, ok := pair.color.ToRGB()
+		if rgb != pair.rgb || ok != pair.ok {
+			t.Errorf("For %#v expected %06x %t got %06x %t", pair.color, pair.rgb, pair.ok, rgb, ok)
+		}
+	}
+
+	for str, expected := range map[string]HexColor{
+		"FF8000": {true, 0xff8000},
+		"ff8000": {true, 0xff8000},
+		"FF80":   {},
+		"FF80ZZ": {},
+	} {
+		if color := ParseHexColor(str); color != expected {
+			t.Errorf("For %q expected %#v got %#v", str, expected, color)
+		}
+	}
+}
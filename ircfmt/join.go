@@ -0,0 +1,224 @@
+package ircfmt
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Join is the inverse of Split: it takes a sequence of substrings with
+// associated formatting information and returns the IRC message that would
+// produce them, using a minimal sequence of formatting control codes.
+// Content is not escaped, so it should not itself contain control codes.
+func Join(chunks []FormattedSubstring) string {
+	var out strings.Builder
+	var current FormattedSubstring
+	for i := range chunks {
+		chunk := &chunks[i]
+		if chunk.Content == "" {
+			continue
+		}
+		out.WriteString(formattingTransition(&current, chunk, chunk.Content))
+		out.WriteString(chunk.Content)
+		current = *chunk
+	}
+	return out.String()
+}
+
+// Substring returns the section of an IRC message whose visible content
+// (i.e., the content returned by Strip) is between the rune indices start
+// (inclusive) and end (exclusive), with the formatting in effect for it.
+// Out-of-range indices are clamped to the bounds of the content.
+func Substring(raw string, start, end int) string {
+	if start < 0 {
+		start = 0
+	}
+	var result []FormattedSubstring
+	pos := 0
+	for _, chunk := range Split(raw) {
+		if pos >= end {
+			break
+		}
+		length := utf8.RuneCountInString(chunk.Content)
+		if pos+length > start {
+			chunk.Content = runeSlice(chunk.Content, start-pos, end-pos)
+			result = append(result, chunk)
+		}
+		pos += length
+	}
+	return Join(result)
+}
+
+// runeSlice returns the runes of str between the indices start and end,
+// clamped to the bounds of str.
+func runeSlice(str string, start, end int) string {
+	startByte, endByte := len(str), len(str)
+	i := 0
+	for byteIdx := range str {
+		if i == start {
+			startByte = byteIdx
+		}
+		if i == end {
+			endByte = byteIdx
+			break
+		}
+		i++
+	}
+	if start <= 0 {
+		startByte = 0
+	}
+	if startByte > endByte {
+		return ""
+	}
+	return str[startByte:endByte]
+}
+
+// Truncate returns the longest prefix of an IRC message, with the formatting
+// in effect for it, whose length in bytes (including formatting codes) is at
+// most maxBytes. Multibyte characters are never split. The result does not
+// end with a reset code, so formatting may carry over to anything appended.
+func Truncate(raw string, maxBytes int) string {
+	if len(raw) <= maxBytes {
+		return raw
+	}
+	var out strings.Builder
+	var current FormattedSubstring
+	for _, chunk := range Split(raw) {
+		codes := formattingTransition(&current, &chunk, chunk.Content)
+		if out.Len()+len(codes)+len(chunk.Content) <= maxBytes {
+			out.WriteString(codes)
+			out.WriteString(chunk.Content)
+			current = chunk
+			continue
+		}
+		// take as much of this chunk as will fit; a prefix never needs
+		// longer codes than the full chunk
+		available := maxBytes - out.Len() - len(codes)
+		prefix := chunk.Content[:0]
+		for i, r := range chunk.Content {
+			if i+utf8.RuneLen(r) > available {
+				break
+			}
+			prefix = chunk.Content[:i+utf8.RuneLen(r)]
+		}
+		if prefix != "" {
+			out.WriteString(formattingTransition(&current, &chunk, prefix))
+			out.WriteString(prefix)
+		}
+		break
+	}
+	return out.String()
+}
+
+// formattingTransition returns the shortest control codes that change the
+// formatting from prev to next, given the content that will follow them.
+func formattingTransition(prev, next *FormattedSubstring, content string) string {
+	toggles := formattingToggles(prev, next, content)
+	var zero FormattedSubstring
+	if reset := reset + formattingToggles(&zero, next, content); len(reset) < len(toggles) {
+		return reset
+	}
+	return toggles
+}
+
+func formattingToggles(prev, next *FormattedSubstring, content string) string {
+	var out strings.Builder
+	colors := colorTransition(prev, next, content)
+	out.WriteString(colors)
+	flags := []struct {
+		prev, next bool
+		code       string
+	}{
+		{prev.Bold, next.Bold, bold},
+		{prev.Monospace, next.Monospace, monospace},
+		{prev.ReverseColor, next.ReverseColor, reverseColour},
+		{prev.Italic, next.Italic, italic},
+		{prev.Strikethrough, next.Strikethrough, strikethrough},
+		{prev.Underline, next.Underline, underline},
+	}
+	toggled := false
+	for _, flag := range flags {
+		if flag.prev != flag.next {
+			out.WriteString(flag.code)
+			toggled = true
+		}
+	}
+	if colors != "" && !toggled && colorCodeAbsorbs(colors, content) {
+		// separate the color code from the content with a no-op
+		out.WriteString(bold + bold)
+	}
+	return out.String()
+}
+
+// colorTransition returns the color codes that change the colors from prev
+// to next; the final number is padded to two digits if it would otherwise
+// run into the following content.
+func colorTransition(prev, next *FormattedSubstring, content string) string {
+	fg, bg := next.colors()
+	prevFg, prevBg := prev.colors()
+	if fg == prevFg && bg == prevBg {
+		return ""
+	}
+	pad := len(content) != 0 && isDigit(rune(content[0]))
+	if !fg.IsSet && !bg.IsSet {
+		return colour
+	}
+
+	if bg == prevBg {
+		if fg.IsRGB {
+			return fmt.Sprintf("%s%06x", hexColour, fg.RGB)
+		}
+		return colour + colorNumber(fg, pad)
+	}
+
+	// the background can only be set along with the foreground, so set it
+	// with a code of its own kind, then fix up the foreground if necessary:
+	if bg.IsRGB {
+		fgHex := bg.RGB
+		if fg.IsRGB {
+			fgHex = fg.RGB
+		}
+		result := fmt.Sprintf("%s%06x,%06x", hexColour, fgHex, bg.RGB)
+		if !fg.IsRGB {
+			result += colour + colorNumber(fg, pad)
+		}
+		return result
+	}
+	if fg.IsRGB {
+		return fmt.Sprintf("%s99,%s%s%06x", colour, colorNumber(bg, false), hexColour, fg.RGB)
+	}
+	return colour + colorNumber(fg, false) + "," + colorNumber(bg, pad)
+}
+
+// colorNumber returns the number of a palette color, or 99 if it is unset.
+func colorNumber(color colorValue, pad bool) string {
+	if !color.IsSet {
+		return "99"
+	}
+	if pad || color.Value >= 10 {
+		return fmt.Sprintf("%02d", color.Value)
+	}
+	return fmt.Sprintf("%d", color.Value)
+}
+
+// colorCodeAbsorbs returns whether the beginning of content would be parsed
+// as part of the final color code in codes.
+func colorCodeAbsorbs(codes, content string) bool {
+	lastColour := strings.LastIndex(codes, colour)
+	lastHexColour := strings.LastIndex(codes, hexColour)
+	if lastHexColour > lastColour {
+		if strings.IndexByte(codes[lastHexColour:], ',') != -1 {
+			return false
+		}
+		return strings.HasPrefix(content, ",") && hexColorForeRe.MatchString(content[1:])
+	}
+	code := codes[lastColour+1:]
+	switch {
+	case code == "":
+		return len(content) != 0 && isDigit(rune(content[0]))
+	case strings.IndexByte(code, ',') == -1:
+		return len(content) >= 2 && content[0] == ',' && isDigit(rune(content[1]))
+	default:
+		return false
+	}
+}
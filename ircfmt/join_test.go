@@ -0,0 +1,127 @@
+package ircfmt
+
+import (
+	"reflect"
+	"testing"
+)
+
+var joinTests = []testcase{
+	// input, and its minimal encoding
+	{"", ""},
+	{"plain", "plain"},
+	{"\x02bold\x02 plain", "\x02bold\x02 plain"},
+	{"\x02\x02\x1d\x1dplain", "plain"},
+	{"\x02\x1d\x1f\x1eall\x0fnone", "\x02\x1d\x1e\x1fall\x0fnone"},
+	{"\x02\x1dboth\x1d\x02none", "\x02\x1dboth\x0fnone"},
+	{"\x0304red\x0304 still red", "\x034red still red"},
+	{"\x034,12a\x0312b", "\x034,12a\x0312b"},
+	{"\x034,12a\x03b", "\x034,12a\x03b"},
+	{"\x034a\x035b\x03c", "\x034a\x035b\x03c"},
+	{"\x0304,0512", "\x034,0512"},
+	{"\x034a\x03051", "\x034a\x03051"},
+	{"\x034a\x031,2b\x0399,2c", "\x034a\x031,2b\x0399c"},
+	{"\x034a\x03\x02\x0212", "\x034a\x0f12"},
+	{"\x0312\x02\x02,5", "\x0312\x02\x02,5"},
+	{"\x0312\x02,5", "\x0312\x02,5"},
+	{"\x034,5a\x034,99b", "\x034,5a\x0f\x034b"},
+	{"\x04ff8000a\x04ff8000,123456b\x0399c", "\x04ff8000a\x04ff8000,123456b\x0399c"},
+	{"\x0399,05\x04123456\x02\x02,abcdef", "\x0399,5\x04123456\x02\x02,abcdef"},
+	{"\x034,5\x04ff8000a", "\x0399,5\x04ff8000a"},
+	{"\x04ff8000,000000\x034a", "\x04000000,000000\x034a"},
+	{"x\x0399,5y\x04ff8000,000000\x03a", "x\x0399,5y\x03a"},
+	{"\x0399,12\x02a", "\x0399,12\x02a"},
+	{"\x02\x1d\x1e\x1f\x11\x16\x034,5a\x02\x1d\x1e\x1f\x11\x16b", "\x034,5\x02\x11\x16\x1d\x1e\x1fa\x0f\x034,5b"},
+}
+
+func TestJoin(t *testing.T) {
+	for i, pair := range joinTests {
+		chunks := Split(pair.escaped)
+		joined := Join(chunks)
+		if joined != pair.unescaped {
+			t.Errorf("Test case %d failed: expected %q, got %q", i, pair.unescaped, joined)
+		}
+		if resplit := Split(joined); !reflect.DeepEqual(resplit, mergeChunks(chunks)) {
+			t.Errorf("Test case %d failed to round-trip: expected %#v, got %#v", i, chunks, resplit)
+		}
+	}
+	// every split test case round-trips:
+	for i, testCase := range splitTestCases {
+		if resplit := Split(Join(testCase.output)); !reflect.DeepEqual(resplit, mergeChunks(testCase.output)) {
+			t.Errorf("Split test case %d failed to round-trip: expected %#v, got %#v", i, testCase.output, resplit)
+		}
+	}
+}
+
+// mergeChunks merges adjacent chunks with identical formatting.
+func mergeChunks(chunks []FormattedSubstring) (result []FormattedSubstring) {
+	for _, chunk := range chunks {
+		if len(result) != 0 {
+			last := &result[len(result)-1]
+			lastFormat, format := *last, chunk
+			lastFormat.Content, format.Content = "", ""
+			if lastFormat == format {
+				last.Content += chunk.Content
+				continue
+			}
+		}
+		result = append(result, chunk)
+	}
+	return
+}
+
+func TestJoinModified(t *testing.T) {
+	chunks := Split("\x02hello\x02 \x034world\x03!")
+	chunks[1].Content = " cruel "
+	chunks[2].Content = "12"
+	if joined := Join(chunks); joined != "\x02hello\x02 cruel \x030412\x03!" {
+		t.Errorf("unexpected result %q", joined)
+	}
+}
+
+func TestSubstring(t *testing.T) {
+	raw := "a\x02b\x1dcd\x02e\x034🐬f\x0fg"
+	for _, testCase := range []struct {
+		start, end int
+		output     string
+	}{
+		{0, 100, "a\x02b\x1dcd\x02e\x034🐬f\x0fg"},
+		{-5, 1, "a"},
+		{1, 2, "\x02b"},
+		{2, 4, "\x02\x1dcd"},
+		{4, 6, "\x1de\x034🐬"},
+		{5, 6, "\x034\x1d🐬"},
+		{7, 8, "g"},
+		{8, 10, ""},
+		{4, 2, ""},
+	} {
+		if actual := Substring(raw, testCase.start, testCase.end); actual != testCase.output {
+			t.Errorf("Substring(%d, %d): expected %q, got %q", testCase.start, testCase.end, testCase.output, actual)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	raw := "a\x02bc\x02\x034🐬d"
+	for _, testCase := range []struct {
+		maxBytes int
+		output   string
+	}{
+		{100, raw},
+		{0, ""},
+		{1, "a"},
+		{2, "a"},
+		{3, "a\x02b"},
+		{5, "a\x02bc"},
+		{6, "a\x02bc"},
+		{8, "a\x02bc"},
+		{11, "a\x02bc\x034\x02🐬"},
+	} {
+		actual := Truncate(raw, testCase.maxBytes)
+		if actual != testCase.output {
+			t.Errorf("Truncate(%d): expected %q, got %q", testCase.maxBytes, testCase.output, actual)
+		}
+		if len(actual) > testCase.maxBytes {
+			t.Errorf("Truncate(%d): result %q is too long", testCase.maxBytes, actual)
+		}
+	}
+}
@@ -0,0 +1,503 @@
+package ircfmt
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// This file converts between IRC formatting and a subset of CommonMark:
+// strong emphasis (bold), emphasis (italics), GFM strikethrough, code spans
+// (monospace) and fenced code blocks. Other IRC formatting (colors, underline
+// and reverse) has no Markdown equivalent and is dropped.
+
+const (
+	// escaped by ToMarkdown wherever they appear
+	markdownMetacharacters = "\\`*_~[]<>#|&"
+)
+
+// ToMarkdown converts an IRC message containing formatting codes into
+// Markdown, escaping any Markdown metacharacters in the content.
+// Emphasis is nested where possible; some formatting changes in the middle
+// of a word have no CommonMark representation.
+func ToMarkdown(raw string) string {
+	var out strings.Builder
+	var open []string  // stack of currently open delimiters
+	var pending string // trailing whitespace, moved outside any closing delimiters
+	atLineStart := true
+	for _, chunk := range mergeMarkdownChunks(Split(raw)) {
+		content := chunk.Content
+		var leading, inner, trailing string
+		if chunk.Monospace {
+			inner = content
+		} else {
+			// emphasis delimiters must not be adjacent to whitespace on the
+			// inside, so move leading and trailing whitespace outside them:
+			trimmed := strings.TrimLeftFunc(content, unicode.IsSpace)
+			inner = strings.TrimRightFunc(trimmed, unicode.IsSpace)
+			leading, trailing = content[:len(content)-len(trimmed)], trimmed[len(inner):]
+			if inner == "" {
+				pending += leading
+				continue
+			}
+		}
+
+		var desired []string
+		if chunk.Strikethrough {
+			desired = append(desired, "~~")
+		}
+		if chunk.Bold {
+			desired = append(desired, "**")
+		}
+		if chunk.Italic {
+			desired = append(desired, "*")
+		}
+		// close everything down to the first delimiter we no longer want:
+		for i, delim := range open {
+			if !containsString(desired, delim) {
+				for j := len(open) - 1; j >= i; j-- {
+					out.WriteString(open[j])
+				}
+				open = open[:i]
+				break
+			}
+		}
+		out.WriteString(pending)
+		out.WriteString(leading)
+		if pending+leading != "" {
+			atLineStart = strings.HasSuffix(pending+leading, "\n")
+		}
+		pending = trailing
+		for _, delim := range desired {
+			if !containsString(open, delim) {
+				out.WriteString(delim)
+				open = append(open, delim)
+			}
+		}
+
+		if chunk.Monospace {
+			out.WriteString(markdownCodeSpan(inner))
+		} else {
+			out.WriteString(escapeMarkdown(inner, atLineStart && len(open) == 0))
+		}
+		atLineStart = false
+	}
+	for j := len(open) - 1; j >= 0; j-- {
+		out.WriteString(open[j])
+	}
+	out.WriteString(pending)
+	return out.String()
+}
+
+func containsString(list []string, str string) bool {
+	for _, s := range list {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeMarkdownChunks removes formatting that Markdown can't represent,
+// then merges adjacent chunks whose remaining formatting is identical.
+func mergeMarkdownChunks(chunks []FormattedSubstring) (result []FormattedSubstring) {
+	for _, chunk := range chunks {
+		chunk = FormattedSubstring{
+			Content:       chunk.Content,
+			Bold:          chunk.Bold,
+			Italic:        chunk.Italic,
+			Strikethrough: chunk.Strikethrough,
+			Monospace:     chunk.Monospace,
+		}
+		if len(result) != 0 {
+			last := &result[len(result)-1]
+			lastFormat, format := *last, chunk
+			lastFormat.Content, format.Content = "", ""
+			if lastFormat == format {
+				last.Content += chunk.Content
+				continue
+			}
+		}
+		result = append(result, chunk)
+	}
+	return
+}
+
+// markdownCodeSpan returns a code span containing content verbatim.
+func markdownCodeSpan(content string) string {
+	if content == "" {
+		return ""
+	}
+	// the delimiting backtick string must be longer than any backtick
+	// string inside the content:
+	longest, run := 0, 0
+	for i := 0; i < len(content); i++ {
+		if content[i] == '`' {
+			run++
+			if run > longest {
+				longest = run
+			}
+		} else {
+			run = 0
+		}
+	}
+	fence := strings.Repeat("`", longest+1)
+	// one space is stripped from each side of the content if both are present,
+	// so pad content that would be ambiguous:
+	if content[0] == '`' || content[len(content)-1] == '`' ||
+		(content[0] == ' ' && content[len(content)-1] == ' ' && strings.Trim(content, " ") != "") {
+		content = " " + content + " "
+	}
+	return fence + content + fence
+}
+
+func escapeMarkdown(content string, atLineStart bool) string {
+	var out strings.Builder
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		if strings.IndexByte(markdownMetacharacters, c) != -1 {
+			out.WriteByte('\\')
+		} else if atLineStart && isMarkdownListMarker(content[i:]) {
+			// "- item", "+ item", "1. item", "1) item"
+			for ; '0' <= content[i] && content[i] <= '9'; i++ {
+				out.WriteByte(content[i])
+			}
+			c = content[i]
+			out.WriteByte('\\')
+		}
+		out.WriteByte(c)
+		atLineStart = c == '\n'
+	}
+	return out.String()
+}
+
+func isMarkdownListMarker(s string) bool {
+	if len(s) != 0 && (s[0] == '-' || s[0] == '+') {
+		return len(s) == 1 || s[1] == ' ' || s[1] == '\t'
+	}
+	digits := 0
+	for digits < len(s) && '0' <= s[digits] && s[digits] <= '9' {
+		digits++
+	}
+	if digits == 0 || digits == len(s) || !(s[digits] == '.' || s[digits] == ')') {
+		return false
+	}
+	return digits+1 == len(s) || s[digits+1] == ' ' || s[digits+1] == '\t'
+}
+
+// FromMarkdown converts Markdown into IRC formatting codes. Strong emphasis
+// becomes bold, emphasis becomes italics, strikethrough (~~text~~ or ~text~)
+// becomes strikethrough, and code spans and each line of fenced code blocks
+// become monospace. Emphasis may span lines within a paragraph, but not a
+// blank line. Backslash escapes are processed; other Markdown syntax is
+// left as-is. Any IRC formatting codes already present in the input are
+// removed.
+func FromMarkdown(md string) string {
+	md = stripMetacharacters(md)
+	lines := strings.Split(md, "\n")
+	// each entry is one or more output lines, to be joined by newlines:
+	var blocks []string
+	var paragraph []string // lines of the current paragraph, if any
+	flushParagraph := func() {
+		if len(paragraph) != 0 {
+			blocks = append(blocks, markdownInlineToIRC(strings.Join(paragraph, "\n")))
+			paragraph = nil
+		}
+	}
+	var fence string // the opening fence of the current code block, if any
+	for _, line := range lines {
+		if fence != "" {
+			if isClosingFence(line, fence) {
+				fence = ""
+			} else if line != "" {
+				blocks = append(blocks, monospace+line+monospace)
+			} else {
+				blocks = append(blocks, "")
+			}
+		} else if f := openingFence(line); f != "" {
+			flushParagraph()
+			fence = f
+		} else if strings.TrimSpace(line) == "" {
+			flushParagraph()
+			blocks = append(blocks, line)
+		} else {
+			paragraph = append(paragraph, line)
+		}
+	}
+	flushParagraph()
+	return strings.Join(blocks, "\n")
+}
+
+func stripMetacharacters(str string) string {
+	if strings.IndexAny(str, metacharacters) == -1 {
+		return str
+	}
+	return strings.Map(func(r rune) rune {
+		if r < utf8.RuneSelf && strings.IndexByte(metacharacters, byte(r)) != -1 {
+			return -1
+		}
+		return r
+	}, str)
+}
+
+// openingFence returns the fence (e.g. "```") if line opens a fenced code block.
+func openingFence(line string) string {
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 || len(trimmed) < 3 || !(trimmed[0] == '`' || trimmed[0] == '~') {
+		return ""
+	}
+	n := 0
+	for n < len(trimmed) && trimmed[n] == trimmed[0] {
+		n++
+	}
+	if n < 3 || (trimmed[0] == '`' && strings.IndexByte(trimmed[n:], '`') != -1) {
+		return ""
+	}
+	return trimmed[:n]
+}
+
+func isClosingFence(line, fence string) bool {
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 {
+		return false
+	}
+	n := 0
+	for n < len(trimmed) && trimmed[n] == fence[0] {
+		n++
+	}
+	return n >= len(fence) && strings.TrimRight(trimmed[n:], " \t") == ""
+}
+
+// markdown inline parsing, following the CommonMark delimiter run algorithm:
+// https://spec.commonmark.org/0.30/#phase-2-inline-structure
+
+type mdStyle uint8
+
+const (
+	mdBold mdStyle = iota
+	mdItalic
+	mdStrikethrough
+	mdMonospace
+	mdNumStyles
+)
+
+var mdStyleCodes = [mdNumStyles]byte{bold[0], italic[0], strikethrough[0], monospace[0]}
+
+type mdMarker struct {
+	style mdStyle
+	open  bool
+}
+
+type mdNode struct {
+	text string
+	// delimiter run state:
+	delim     byte
+	count     int
+	origCount int
+	canOpen   bool
+	canClose  bool
+	active    bool
+	// whether the delimiter run closed any emphasis; if so, its unused
+	// delimiters are literal text after its markers, otherwise before them
+	closed bool
+	// style changes before and after the node's text:
+	before []mdMarker
+	after  []mdMarker
+}
+
+// markdownInlineToIRC converts the inline content of a paragraph, which may
+// contain newlines.
+func markdownInlineToIRC(line string) string {
+	var nodes []mdNode
+	var text strings.Builder
+	flushText := func() {
+		if text.Len() != 0 {
+			nodes = append(nodes, mdNode{text: text.String()})
+			text.Reset()
+		}
+	}
+
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case c == '\\' && i+1 < len(line) && isASCIIPunct(line[i+1]):
+			text.WriteByte(line[i+1])
+			i += 2
+		case c == '`':
+			n := runLength(line, i)
+			end, contentEnd := findClosingBackticks(line, i+n, n)
+			if end == -1 {
+				text.WriteString(line[i : i+n])
+				i += n
+				continue
+			}
+			flushText()
+			content := line[i+n : contentEnd]
+			if len(content) >= 2 && content[0] == ' ' && content[len(content)-1] == ' ' && strings.Trim(content, " ") != "" {
+				content = content[1 : len(content)-1]
+			}
+			nodes = append(nodes, mdNode{
+				text:   content,
+				before: []mdMarker{{mdMonospace, true}},
+				after:  []mdMarker{{mdMonospace, false}},
+			})
+			i = end
+		case c == '*' || c == '_' || c == '~':
+			n := runLength(line, i)
+			if c == '~' && n > 2 {
+				text.WriteString(line[i : i+n])
+				i += n
+				continue
+			}
+			flushText()
+			before, _ := utf8.DecodeLastRuneInString(line[:i])
+			after, _ := utf8.DecodeRuneInString(line[i+n:])
+			if i == 0 {
+				before = ' '
+			}
+			if i+n == len(line) {
+				after = ' '
+			}
+			left := !unicode.IsSpace(after) && (!isMarkdownPunct(after) || unicode.IsSpace(before) || isMarkdownPunct(before))
+			right := !unicode.IsSpace(before) && (!isMarkdownPunct(before) || unicode.IsSpace(after) || isMarkdownPunct(after))
+			node := mdNode{delim: c, count: n, origCount: n, active: true, canOpen: left, canClose: right}
+			if c == '_' {
+				node.canOpen = left && (!right || isMarkdownPunct(before))
+				node.canClose = right && (!left || isMarkdownPunct(after))
+			}
+			nodes = append(nodes, node)
+			i += n
+		default:
+			text.WriteByte(c)
+			i++
+		}
+	}
+	flushText()
+
+	processEmphasis(nodes)
+
+	// render, toggling each style only when it actually changes:
+	var depth [mdNumStyles]int
+	var out strings.Builder
+	apply := func(markers []mdMarker) {
+		for _, m := range markers {
+			if m.open {
+				depth[m.style]++
+				if depth[m.style] == 1 {
+					out.WriteByte(mdStyleCodes[m.style])
+				}
+			} else {
+				depth[m.style]--
+				if depth[m.style] == 0 {
+					out.WriteByte(mdStyleCodes[m.style])
+				}
+			}
+		}
+	}
+	for _, node := range nodes {
+		if node.delim != 0 {
+			literal := strings.Repeat(string(node.delim), node.count)
+			if node.closed {
+				apply(node.before)
+				out.WriteString(literal)
+			} else {
+				out.WriteString(literal)
+				apply(node.before)
+			}
+			continue
+		}
+		apply(node.before)
+		out.WriteString(node.text)
+		apply(node.after)
+	}
+	return out.String()
+}
+
+func processEmphasis(nodes []mdNode) {
+	for closer := 0; closer < len(nodes); closer++ {
+		for nodes[closer].active && nodes[closer].canClose && nodes[closer].count != 0 {
+			c := &nodes[closer]
+			opener := -1
+			for j := closer - 1; j >= 0; j-- {
+				o := &nodes[j]
+				if !o.active || !o.canOpen || o.delim != c.delim || o.count == 0 {
+					continue
+				}
+				if c.delim == '~' {
+					if o.count != c.count {
+						continue
+					}
+				} else if (o.canClose || c.canOpen) && (o.origCount+c.origCount)%3 == 0 &&
+					!(o.origCount%3 == 0 && c.origCount%3 == 0) {
+					continue
+				}
+				opener = j
+				break
+			}
+			if opener == -1 {
+				if !c.canOpen {
+					c.active = false
+				}
+				break
+			}
+
+			o := &nodes[opener]
+			use := 1
+			if o.count >= 2 && c.count >= 2 {
+				use = 2
+			}
+			style := mdItalic
+			if c.delim == '~' {
+				style, use = mdStrikethrough, c.count
+			} else if use == 2 {
+				style = mdBold
+			}
+			o.count -= use
+			c.count -= use
+			// later matches enclose earlier ones:
+			o.before = append([]mdMarker{{style, true}}, o.before...)
+			c.before = append(c.before, mdMarker{style, false})
+			c.closed = true
+			for j := opener + 1; j < closer; j++ {
+				if nodes[j].delim != 0 {
+					nodes[j].active = false
+				}
+			}
+			if o.count == 0 {
+				o.active = false
+			}
+		}
+	}
+}
+
+func runLength(line string, i int) (n int) {
+	for i+n < len(line) && line[i+n] == line[i] {
+		n++
+	}
+	return
+}
+
+// findClosingBackticks finds a backtick string of exactly length n starting
+// at or after start, returning the index after it and the index where it begins.
+func findClosingBackticks(line string, start, n int) (end, contentEnd int) {
+	for i := start; i < len(line); {
+		if line[i] != '`' {
+			i++
+			continue
+		}
+		run := runLength(line, i)
+		if run == n {
+			return i + run, i
+		}
+		i += run
+	}
+	return -1, -1
+}
+
+func isASCIIPunct(c byte) bool {
+	return ('!' <= c && c <= '/') || (':' <= c && c <= '@') || ('[' <= c && c <= '`') || ('{' <= c && c <= '~')
+}
+
+func isMarkdownPunct(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
@@ -0,0 +1,107 @@
+package ircfmt
+
+import (
+	"reflect"
+	"testing"
+	"unicode"
+)
+
+var toMarkdownTests = []testcase{
+	{"plain text", "plain text"},
+	{"\x02bold\x02 text", "**bold** text"},
+	{"\x1ditalic \x1etext\x1d more", "*italic ~~text~~* ~~more~~"},
+	{"\x02\x1dboth\x0f", "***both***"},
+	{"\x02a \x1db\x1d c\x02", "**a *b* c**"},
+	{"\x02a\x1db\x1d\x02", "**a*b***"},
+	{"\x02spaced \x02out", "**spaced** out"},
+	{"\x034,5col\x03ors \x1fand\x1f \x16reverse", "colors and reverse"},
+	{"\x0304,05bo\x0306ld\x02\x02", "bold"},
+	{"\x02bo\x034ld", "**bold**"},
+	{"\x11code *here*\x11 and *there*", "`code *here*` and \\*there\\*"},
+	{"\x11a `tick`\x11", "`` a `tick` ``"},
+	{"\x11 x \x11", "`  x  `"},
+	{"\x02\x11code\x11\x02", "**`code`**"},
+	{"# not a _header_ [or] <a link> & stuff\\", "\\# not a \\_header\\_ \\[or\\] \\<a link\\> \\& stuff\\\\"},
+	{"- not a list", "\\- not a list"},
+	{"12. not a list", "12\\. not a list"},
+	{"a - b 1. c", "a - b 1. c"},
+	{"\x02a\nb\x02", "**a\nb**"},
+	{"\x1done\n- two\x1d", "*one\n\\- two*"},
+}
+
+var fromMarkdownTests = []testcase{
+	{"plain text", "plain text"},
+	{"**bold** text", "\x02bold\x02 text"},
+	{"__bold__ _italic_ *italic*", "\x02bold\x02 \x1ditalic\x1d \x1ditalic\x1d"},
+	{"***both***", "\x1d\x02both\x02\x1d"},
+	{"~~strike~~ ~strike~ ~~~not~~~", "\x1estrike\x1e \x1estrike\x1e ~~~not~~~"},
+	{"**a *b* c**", "\x02a \x1db\x1d c\x02"},
+	{"**a **b** c**", "\x02a b c\x02"},
+	{"snake_case_name and 2*3*4", "snake_case_name and 2\x1d3\x1d4"},
+	{"* not emphasis *", "* not emphasis *"},
+	{"**unclosed", "**unclosed"},
+	{"*a **b***", "\x1da \x02b\x02\x1d"},
+	{"`code *not emphasis*` and `` a `b` ``", "\x11code *not emphasis*\x11 and \x11a `b`\x11"},
+	{"`unclosed code", "`unclosed code"},
+	{"\\*escaped\\* \\\\ \\a", "*escaped* \\ \\a"},
+	{"strip \x02irc\x02 codes", "strip irc codes"},
+	{"before\n```go\nfunc main() {\n\n}\n```\nafter", "before\n\x11func main() {\x11\n\n\x11}\x11\nafter"},
+	{"~~~\nunclosed *block*", "\x11unclosed *block*\x11"},
+	{"**a\nb** _c\nd_", "\x02a\nb\x02 \x1dc\nd\x1d"},
+	{"**a\n\nb**", "**a\n\nb**"},
+	{"*a\n```\nb*\n```", "*a\n\x11b*\x11"},
+}
+
+func TestToMarkdown(t *testing.T) {
+	for i, pair := range toMarkdownTests {
+		actual := ToMarkdown(pair.escaped)
+		if actual != pair.unescaped {
+			t.Errorf("Test case %d failed: expected %q, got %q", i, pair.unescaped, actual)
+		}
+	}
+}
+
+func TestFromMarkdown(t *testing.T) {
+	for i, pair := range fromMarkdownTests {
+		actual := FromMarkdown(pair.escaped)
+		if actual != pair.unescaped {
+			t.Errorf("Test case %d failed: expected %q, got %q", i, pair.unescaped, actual)
+		}
+	}
+}
+
+func TestMarkdownRoundTrip(t *testing.T) {
+	// IRC -> Markdown -> IRC preserves the formatting that Markdown supports:
+	for i, pair := range toMarkdownTests {
+		expected := mergeMarkdownChunks(Split(pair.escaped))
+		actual := mergeMarkdownChunks(Split(FromMarkdown(ToMarkdown(pair.escaped))))
+		if !reflect.DeepEqual(normalizeMarkdownChunks(expected), normalizeMarkdownChunks(actual)) {
+			t.Errorf("Test case %d failed: expected %#v, got %#v", i, expected, actual)
+		}
+	}
+	// Markdown -> IRC -> Markdown -> IRC is stable:
+	for i, pair := range fromMarkdownTests {
+		irc := FromMarkdown(pair.escaped)
+		again := FromMarkdown(ToMarkdown(irc))
+		if !reflect.DeepEqual(normalizeMarkdownChunks(Split(again)), normalizeMarkdownChunks(Split(irc))) {
+			t.Errorf("Test case %d failed: expected %q, got %q", i, irc, again)
+		}
+	}
+}
+
+// normalizeMarkdownChunks drops emphasis from whitespace outside code,
+// which ToMarkdown moves outside the delimiters.
+func normalizeMarkdownChunks(chunks []FormattedSubstring) []FormattedSubstring {
+	var runes []FormattedSubstring
+	for _, chunk := range chunks {
+		for _, r := range chunk.Content {
+			c := chunk
+			c.Content = string(r)
+			if !c.Monospace && unicode.IsSpace(r) {
+				c = FormattedSubstring{Content: c.Content}
+			}
+			runes = append(runes, c)
+		}
+	}
+	return mergeMarkdownChunks(runes)
+}
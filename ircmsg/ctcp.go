@@ -0,0 +1,135 @@
+package ircmsg
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	ctcpDelim = '\x01'
+	// low-level quoting character (M-QUOTE)
+	ctcpQuote = '\x10'
+)
+
+var (
+	// ErrorBadCTCP indicates that a CTCP command or argument could not be
+	// encoded, e.g. because it contained the \x01 delimiter.
+	ErrorBadCTCP = errors.New("CTCP command must be non-empty, and the command and argument cannot contain \\x01")
+
+	// ctcpLowQuoter implements low-level quoting of the characters that cannot
+	// appear in an IRC line (plus the quoting character itself).
+	ctcpLowQuoter = strings.NewReplacer("\x10", "\x10\x10", "\x00", "\x100", "\n", "\x10n", "\r", "\x10r")
+)
+
+// CTCP represents a Client-To-Client Protocol message embedded in the final
+// parameter of a PRIVMSG (for requests) or a NOTICE (for replies), as per
+// this de facto specification: https://modern.ircdocs.horse/ctcp.html
+type CTCP struct {
+	// Command is the CTCP command (e.g., "VERSION" or "ACTION"),
+	// normalized to uppercase.
+	Command string
+	// Argument is everything after the first space following the command,
+	// with low-level quoting removed; it may be empty.
+	Argument string
+}
+
+// DecodeCTCP decodes the text of a PRIVMSG or NOTICE as a CTCP message.
+// If the text is not a CTCP message, ok is false. As per the specification,
+// the trailing \x01 delimiter is optional.
+func DecodeCTCP(text string) (result CTCP, ok bool) {
+	if len(text) < 2 || text[0] != ctcpDelim {
+		return
+	}
+	text = text[1:]
+	if text[len(text)-1] == ctcpDelim {
+		text = text[:len(text)-1]
+	}
+	text = lowDequote(text)
+	spaceIdx := strings.IndexByte(text, ' ')
+	if spaceIdx == -1 {
+		result.Command = text
+	} else {
+		result.Command, result.Argument = text[:spaceIdx], text[spaceIdx+1:]
+	}
+	if result.Command == "" {
+		return CTCP{}, false
+	}
+	result.Command = strings.ToUpper(result.Command)
+	return result, true
+}
+
+// ParseCTCP decodes a PRIVMSG (a CTCP request) or NOTICE (a CTCP reply)
+// as a CTCP message. If the message is not a CTCP message, ok is false.
+func ParseCTCP(msg *Message) (result CTCP, ok bool) {
+	if !(msg.Command == "PRIVMSG" || msg.Command == "NOTICE") || len(msg.Params) != 2 {
+		return
+	}
+	return DecodeCTCP(msg.Params[1])
+}
+
+// EncodeCTCP encodes a CTCP message for use as the text of a PRIVMSG or NOTICE,
+// applying low-level quoting to characters that cannot appear in IRC lines.
+func EncodeCTCP(command, argument string) (result string, err error) {
+	if command == "" || strings.IndexByte(command, ' ') != -1 ||
+		strings.IndexByte(command, ctcpDelim) != -1 || strings.IndexByte(argument, ctcpDelim) != -1 {
+		return "", ErrorBadCTCP
+	}
+	var buf strings.Builder
+	buf.Grow(len(command) + len(argument) + 3)
+	buf.WriteByte(ctcpDelim)
+	buf.WriteString(ctcpLowQuoter.Replace(command))
+	if argument != "" {
+		buf.WriteByte(' ')
+		buf.WriteString(ctcpLowQuoter.Replace(argument))
+	}
+	buf.WriteByte(ctcpDelim)
+	return buf.String(), nil
+}
+
+// MakeCTCPRequest creates a PRIVMSG containing a CTCP request.
+func MakeCTCPRequest(target, command, argument string) (msg Message, err error) {
+	text, err := EncodeCTCP(command, argument)
+	if err != nil {
+		return
+	}
+	return MakeMessage(nil, "", "PRIVMSG", target, text), nil
+}
+
+// MakeCTCPReply creates a NOTICE containing a CTCP reply.
+func MakeCTCPReply(target, command, argument string) (msg Message, err error) {
+	text, err := EncodeCTCP(command, argument)
+	if err != nil {
+		return
+	}
+	return MakeMessage(nil, "", "NOTICE", target, text), nil
+}
+
+// reverse the low-level quoting; unknown quoted characters map to themselves
+func lowDequote(in string) string {
+	if strings.IndexByte(in, ctcpQuote) == -1 {
+		return in
+	}
+	var buf strings.Builder
+	buf.Grow(len(in))
+	for i := 0; i < len(in); i++ {
+		if in[i] != ctcpQuote {
+			buf.WriteByte(in[i])
+			continue
+		}
+		i++
+		if i == len(in) {
+			break
+		}
+		switch in[i] {
+		case '0':
+			buf.WriteByte('\x00')
+		case 'n':
+			buf.WriteByte('\n')
+		case 'r':
+			buf.WriteByte('\r')
+		default:
+			buf.WriteByte(in[i])
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,87 @@
+package ircmsg
+
+import (
+	"testing"
+)
+
+type ctcpTest struct {
+	text string
+	ctcp CTCP
+	ok   bool
+}
+
+var ctcpDecodeTests = []ctcpTest{
+	{"\x01VERSION\x01", CTCP{"VERSION", ""}, true},
+	{"\x01version\x01", CTCP{"VERSION", ""}, true},
+	{"\x01ACTION waves\x01", CTCP{"ACTION", "waves"}, true},
+	{"\x01ACTION waves", CTCP{"ACTION", "waves"}, true},
+	{"\x01ACTION \x01", CTCP{"ACTION", ""}, true},
+	{"\x01PING 1234 5678\x01", CTCP{"PING", "1234 5678"}, true},
+	{"\x01DCC SEND a\x10nb\x10\x10 \x100 0\x01", CTCP{"DCC", "SEND a\nb\x10 \x00 0"}, true},
+	{"hello", CTCP{}, false},
+	{"", CTCP{}, false},
+	{"\x01", CTCP{}, false},
+	{"\x01\x01", CTCP{}, false},
+	{"\x01 VERSION\x01", CTCP{}, false},
+}
+
+func TestDecodeCTCP(t *testing.T) {
+	for _, test := range ctcpDecodeTests {
+		ctcp, ok := DecodeCTCP(test.text)
+		if ok != test.ok || ctcp != test.ctcp {
+			t.Errorf("decoding %q: expected %#v (%t), got %#v (%t)", test.text, test.ctcp, test.ok, ctcp, ok)
+		}
+	}
+}
+
+func TestParseCTCP(t *testing.T) {
+	msg, _ := ParseLine(":dan!d@localhost PRIVMSG me :\x01TIME\x01")
+	ctcp, ok := ParseCTCP(&msg)
+	assertEqual(ok, true)
+	assertEqual(ctcp, CTCP{"TIME", ""})
+
+	msg, _ = ParseLine(":dan!d@localhost NOTICE me :\x01TIME Sat, 17 Oct 2026 00:00:00 UTC\x01")
+	ctcp, ok = ParseCTCP(&msg)
+	assertEqual(ok, true)
+	assertEqual(ctcp, CTCP{"TIME", "Sat, 17 Oct 2026 00:00:00 UTC"})
+
+	msg, _ = ParseLine(":dan!d@localhost TAGMSG me :\x01TIME\x01")
+	_, ok = ParseCTCP(&msg)
+	assertEqual(ok, false)
+}
+
+func TestEncodeCTCP(t *testing.T) {
+	text, err := EncodeCTCP("VERSION", "")
+	assertEqual(text, "\x01VERSION\x01")
+	assertEqual(err, nil)
+
+	text, err = EncodeCTCP("ACTION", "waves")
+	assertEqual(text, "\x01ACTION waves\x01")
+	assertEqual(err, nil)
+
+	text, err = EncodeCTCP("DCC", "SEND a\nb\x10 \x00 0\r")
+	assertEqual(text, "\x01DCC SEND a\x10nb\x10\x10 \x100 0\x10r\x01")
+	assertEqual(err, nil)
+	ctcp, ok := DecodeCTCP(text)
+	assertEqual(ok, true)
+	assertEqual(ctcp, CTCP{"DCC", "SEND a\nb\x10 \x00 0\r"})
+
+	_, err = EncodeCTCP("", "hi")
+	assertEqual(err, ErrorBadCTCP)
+	_, err = EncodeCTCP("ACTION", "wa\x01ves")
+	assertEqual(err, ErrorBadCTCP)
+	_, err = EncodeCTCP("ACTION waves", "")
+	assertEqual(err, ErrorBadCTCP)
+
+	msg, err := MakeCTCPRequest("#chat", "ACTION", "waves")
+	assertEqual(err, nil)
+	line, err := msg.Line()
+	assertEqual(err, nil)
+	assertEqual(line, "PRIVMSG #chat :\x01ACTION waves\x01\r\n")
+
+	msg, err = MakeCTCPReply("dan", "PING", "1234")
+	assertEqual(err, nil)
+	line, err = msg.Line()
+	assertEqual(err, nil)
+	assertEqual(line, "NOTICE dan :\x01PING 1234\x01\r\n")
+}
@@ -0,0 +1,130 @@
+package ircmsg
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// jsonMessage is the structured JSON representation of a Message.
+type jsonMessage struct {
+	Tags           map[string]string `json:"tags,omitempty"`
+	ClientOnlyTags map[string]string `json:"clientOnlyTags,omitempty"`
+	Source         string            `json:"source,omitempty"`
+	Command        string            `json:"command"`
+	Params         []string          `json:"params,omitempty"`
+	ForceTrailing  bool              `json:"forceTrailing,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler. Tags, client-only tags, and whether
+// the final parameter is forced to be trailing are all preserved. Note that
+// JSON cannot represent strings that are not valid UTF8; invalid bytes in the
+// source or parameters are replaced by encoding/json with U+FFFD. Use
+// MarshalText if byte-exact serialization of such messages is required.
+//
+// (This and MarshalText use value receivers, so that a Message is serialized
+// correctly even when it is not addressable, e.g., as a map value.)
+func (msg Message) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonMessage{
+		Tags:           msg.tags,
+		ClientOnlyTags: msg.clientOnlyTags,
+		Source:         msg.Source,
+		Command:        msg.Command,
+		Params:         msg.Params,
+		ForceTrailing:  msg.forceTrailing,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (msg *Message) UnmarshalJSON(data []byte) (err error) {
+	var jm jsonMessage
+	if err = json.Unmarshal(data, &jm); err != nil {
+		return
+	}
+	for name := range jm.Tags {
+		if strings.HasPrefix(name, "+") {
+			return ErrorInvalidTagContent
+		}
+	}
+	for name := range jm.ClientOnlyTags {
+		if !strings.HasPrefix(name, "+") {
+			return ErrorInvalidTagContent
+		}
+	}
+	*msg = Message{
+		Source:         jm.Source,
+		Command:        jm.Command,
+		Params:         jm.Params,
+		forceTrailing:  jm.ForceTrailing,
+		tags:           jm.Tags,
+		clientOnlyTags: jm.ClientOnlyTags,
+	}
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler, using the IRC wire format
+// (without the terminating \r\n).
+func (msg Message) MarshalText() ([]byte, error) {
+	line, err := msg.LineBytes()
+	if err != nil {
+		return nil, err
+	}
+	return line[:len(line)-2], nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, parsing the IRC wire
+// format. If the final parameter was encoded as a trailing parameter
+// without needing to be, ForceTrailing is set on the result, so that
+// MarshalText will reproduce the original encoding.
+func (msg *Message) UnmarshalText(text []byte) error {
+	line := string(text)
+	parsed, err := ParseLine(line)
+	if err != nil {
+		return err
+	}
+	if 0 < len(parsed.Params) {
+		last := parsed.Params[len(parsed.Params)-1]
+		if !paramRequiresTrailing(last) {
+			line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+			// a non-trailing parameter is always preceded by a space, not a colon:
+			if strings.HasSuffix(line, " :"+last) {
+				parsed.forceTrailing = true
+			}
+		}
+	}
+	*msg = parsed
+	return nil
+}
+
+// jsonNUH is the structured JSON representation of a NUH.
+type jsonNUH struct {
+	Name string `json:"name"`
+	User string `json:"user,omitempty"`
+	Host string `json:"host,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (nuh NUH) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonNUH{Name: nuh.Name, User: nuh.User, Host: nuh.Host})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (nuh *NUH) UnmarshalJSON(data []byte) (err error) {
+	var jn jsonNUH
+	if err = json.Unmarshal(data, &jn); err != nil {
+		return
+	}
+	*nuh = NUH{Name: jn.Name, User: jn.User, Host: jn.Host}
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler, using the canonical
+// name!user@host form.
+func (nuh NUH) MarshalText() ([]byte, error) {
+	return []byte(nuh.Canonical()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, as per ParseNUH.
+func (nuh *NUH) UnmarshalText(text []byte) (err error) {
+	*nuh, err = ParseNUH(string(text))
+	return
+}
@@ -0,0 +1,143 @@
+package ircmsg
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+var marshalTests = []string{
+	"PING",
+	":dan-!d@localhost PRIVMSG dan #test :What a cool message",
+	"@time=2848;+draft/react=\\s;draft/label=l :dan-!d@localhost PRIVMSG #chat :hi",
+	"@+draft/typing=active TAGMSG #chat",
+	":dan-!d@localhost PRIVMSG a:b ::hi",
+	":dan-!d@localhost PRIVMSG a:b :",
+}
+
+func TestMarshalJSONRoundTrip(t *testing.T) {
+	for _, line := range marshalTests {
+		msg, err := ParseLine(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, forceTrailing := range []bool{false, true} {
+			if forceTrailing {
+				msg.ForceTrailing()
+			}
+			data, err := json.Marshal(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decoded Message
+			if err := json.Unmarshal(data, &decoded); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(msg, decoded) {
+				t.Errorf("JSON round trip of %q failed: expected %#v, got %#v", line, msg, decoded)
+			}
+		}
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	msg := MakeMessage(map[string]string{"time": "2848", "+draft/reply": "123"}, "dan", "PRIVMSG", "#chat", "hi")
+	msg.ForceTrailing()
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(string(data), `{"tags":{"time":"2848"},"clientOnlyTags":{"+draft/reply":"123"},"source":"dan","command":"PRIVMSG","params":["#chat","hi"],"forceTrailing":true}`)
+
+	// a Message that is not addressable must still use the custom encoding:
+	data, err = json.Marshal(map[string]Message{"a": msg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(string(data), `{"a":{"tags":{"time":"2848"},"clientOnlyTags":{"+draft/reply":"123"},"source":"dan","command":"PRIVMSG","params":["#chat","hi"],"forceTrailing":true}}`)
+
+	var decoded Message
+	err = json.Unmarshal([]byte(`{"tags":{"+draft/reply":"123"},"command":"PRIVMSG"}`), &decoded)
+	assertEqual(err, ErrorInvalidTagContent)
+	err = json.Unmarshal([]byte(`{"clientOnlyTags":{"time":"2848"},"command":"PRIVMSG"}`), &decoded)
+	assertEqual(err, ErrorInvalidTagContent)
+}
+
+func TestMarshalText(t *testing.T) {
+	for _, line := range marshalTests {
+		var msg Message
+		if err := msg.UnmarshalText([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+		text, err := msg.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the tag order is unspecified, so compare the parsed forms:
+		var reparsed Message
+		if err := reparsed.UnmarshalText(text); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(msg, reparsed) {
+			t.Errorf("text round trip of %q failed: expected %#v, got %#v", line, msg, reparsed)
+		}
+	}
+
+	// trailing-ness is preserved:
+	var msg Message
+	if err := msg.UnmarshalText([]byte("PRIVMSG #chat :hi\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	text, _ := msg.MarshalText()
+	assertEqual(string(text), "PRIVMSG #chat :hi")
+	if err := msg.UnmarshalText([]byte("PRIVMSG #chat hi")); err != nil {
+		t.Fatal(err)
+	}
+	text, _ = msg.MarshalText()
+	assertEqual(string(text), "PRIVMSG #chat hi")
+	if err := msg.UnmarshalText([]byte("PRIVMSG #chat hi:")); err != nil {
+		t.Fatal(err)
+	}
+	text, _ = msg.MarshalText()
+	assertEqual(string(text), "PRIVMSG #chat hi:")
+
+	// invalid UTF8 survives the text encoding:
+	if err := msg.UnmarshalText([]byte("PRIVMSG #chat :\xf0hi\xf0")); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(msg.Params[1], "\xf0hi\xf0")
+
+	if err := msg.UnmarshalText([]byte("")); err != ErrorLineIsEmpty {
+		t.Errorf("expected empty line error, got %v", err)
+	}
+}
+
+func TestMarshalNUH(t *testing.T) {
+	for _, test := range nuhTests {
+		data, err := json.Marshal(test.NUH)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded NUH
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		assertEqualNUH(decoded, test.NUH)
+
+		if test.Canonical {
+			text, err := test.NUH.MarshalText()
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertEqual(string(text), test.Source)
+			var decoded NUH
+			if err := decoded.UnmarshalText(text); err != nil {
+				t.Fatal(err)
+			}
+			assertEqualNUH(decoded, test.NUH)
+		}
+	}
+
+	data, _ := json.Marshal(NUH{"coolguy", "~ag", "localhost"})
+	assertEqual(string(data), `{"name":"coolguy","user":"~ag","host":"localhost"}`)
+}
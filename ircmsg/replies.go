@@ -0,0 +1,179 @@
+package ircmsg
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Helpers for servers (and bouncers) building replies to clients.
+
+const (
+	// MaxlenLine is the traditional limit on the length of an IRC line,
+	// excluding tags but including the trailing \r\n.
+	MaxlenLine = 512
+
+	// the maximum number of RPL_ISUPPORT tokens sent in a single line;
+	// together with the nick and the final description, this stays within
+	// the RFC 1459 limit of 15 parameters
+	maxISupportTokensPerLine = 13
+
+	isupportDescription = "are supported by this server"
+)
+
+var (
+	// ErrorISupportTokenTooLong indicates that an RPL_ISUPPORT token could
+	// not fit in a line of the specified length.
+	ErrorISupportTokenTooLong = errors.New("ISUPPORT token cannot fit in a single line")
+)
+
+// Standard reply types, as per https://ircv3.net/specs/extensions/standard-replies
+const (
+	StandardReplyFail = "FAIL"
+	StandardReplyWarn = "WARN"
+	StandardReplyNote = "NOTE"
+)
+
+// MakeNumeric creates a numeric reply (e.g. "001") to the client whose
+// nickname is nick, which becomes the first parameter. An empty nick
+// (i.e. that of an unregistered client) is sent as "*".
+func MakeNumeric(source, nick, numeric string, params ...string) Message {
+	if nick == "" {
+		nick = "*"
+	}
+	allParams := make([]string, 0, len(params)+1)
+	allParams = append(allParams, nick)
+	allParams = append(allParams, params...)
+	return MakeMessage(nil, source, numeric, allParams...)
+}
+
+// MakeStandardReply creates a FAIL, WARN or NOTE message. command is the
+// command the reply relates to ("*" if it is empty), code is the
+// machine-readable reply code (e.g. "ACCOUNT_REQUIRED"), context holds
+// any additional parameters, and description is the human-readable text.
+func MakeStandardReply(source, replyType, command, code string, context []string, description string) Message {
+	if command == "" {
+		command = "*"
+	}
+	params := make([]string, 0, len(context)+3)
+	params = append(params, command, code)
+	params = append(params, context...)
+	params = append(params, description)
+	return MakeMessage(nil, source, replyType, params...)
+}
+
+// MakeFail creates a FAIL standard reply.
+func MakeFail(source, command, code, description string, context ...string) Message {
+	return MakeStandardReply(source, StandardReplyFail, command, code, context, description)
+}
+
+// MakeWarn creates a WARN standard reply.
+func MakeWarn(source, command, code, description string, context ...string) Message {
+	return MakeStandardReply(source, StandardReplyWarn, command, code, context, description)
+}
+
+// MakeNote creates a NOTE standard reply.
+func MakeNote(source, command, code, description string, context ...string) Message {
+	return MakeStandardReply(source, StandardReplyNote, command, code, context, description)
+}
+
+// ISupportToken is a single RPL_ISUPPORT (005) token, e.g. NETWORK=Example.
+type ISupportToken struct {
+	Name string
+	// Value is the (unescaped) value; if empty, the token is sent without one.
+	Value string
+	// Negate sends the token as -Name, withdrawing a previously advertised token.
+	Negate bool
+}
+
+// String returns the token as it appears in RPL_ISUPPORT, with its value escaped.
+func (token ISupportToken) String() string {
+	if token.Negate {
+		return "-" + token.Name
+	} else if token.Value == "" {
+		return token.Name
+	}
+	return token.Name + "=" + EscapeISupportValue(token.Value)
+}
+
+// MakeISupport creates the RPL_ISUPPORT (005) lines advertising tokens to
+// the client whose nickname is nick, splitting the tokens so that each line
+// (excluding tags, but including \r\n) is at most maxLineLen bytes long
+// (MaxlenLine if it is 0) and has at most 13 tokens.
+func MakeISupport(source, nick string, tokens []ISupportToken, maxLineLen int) (result []Message, err error) {
+	if maxLineLen == 0 {
+		maxLineLen = MaxlenLine
+	}
+	empty := MakeNumeric(source, nick, "005", isupportDescription)
+	emptyLine, err := empty.Line()
+	if err != nil {
+		return nil, err
+	}
+	// every token costs its length plus a space
+	budget := maxLineLen - len(emptyLine)
+
+	var current []string
+	used := 0
+	flush := func() {
+		if len(current) == 0 {
+			return
+		}
+		params := append(current, isupportDescription)
+		result = append(result, MakeNumeric(source, nick, "005", params...))
+		current = nil
+		used = 0
+	}
+	for _, token := range tokens {
+		str := token.String()
+		if budget < len(str)+1 {
+			return nil, ErrorISupportTokenTooLong
+		}
+		if budget < used+len(str)+1 || len(current) == maxISupportTokensPerLine {
+			flush()
+		}
+		current = append(current, str)
+		used += len(str) + 1
+	}
+	flush()
+	return result, nil
+}
+
+// EscapeISupportValue escapes an RPL_ISUPPORT value, encoding spaces,
+// backslashes, equals signs and control characters as \xHH.
+func EscapeISupportValue(value string) string {
+	var buf strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c <= ' ' || c == '\\' || c == '=' || c == 0x7f {
+			buf.WriteString(`\x`)
+			if c < 0x10 {
+				buf.WriteByte('0')
+			}
+			buf.WriteString(strings.ToUpper(strconv.FormatUint(uint64(c), 16)))
+		} else {
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
+}
+
+// UnescapeISupportValue reverses EscapeISupportValue, decoding \xHH escapes.
+func UnescapeISupportValue(in string) (out string) {
+	if strings.IndexByte(in, '\\') == -1 {
+		return in
+	}
+	var buf strings.Builder
+	for i := 0; i < len(in); {
+		if in[i] == '\\' && i+3 < len(in) && in[i+1] == 'x' {
+			hex := in[i+2 : i+4]
+			if octet, err := strconv.ParseUint(hex, 16, 8); err == nil {
+				buf.WriteByte(byte(octet))
+				i += 4
+				continue
+			}
+		}
+		buf.WriteByte(in[i])
+		i++
+	}
+	return buf.String()
+}
@@ -0,0 +1,95 @@
+package ircmsg
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMakeNumeric(t *testing.T) {
+	msg := MakeNumeric("irc.example.com", "dan", "001", "Welcome to the network, dan")
+	line, _ := msg.Line()
+	assertEqual(line, ":irc.example.com 001 dan :Welcome to the network, dan\r\n")
+
+	msg = MakeNumeric("irc.example.com", "", "451", "You have not registered")
+	line, _ = msg.Line()
+	assertEqual(line, ":irc.example.com 451 * :You have not registered\r\n")
+}
+
+func TestMakeStandardReply(t *testing.T) {
+	msg := MakeFail("irc.example.com", "REGISTER", "ACCOUNT_EXISTS", "Account already exists", "dan")
+	line, _ := msg.Line()
+	assertEqual(line, ":irc.example.com FAIL REGISTER ACCOUNT_EXISTS dan :Account already exists\r\n")
+
+	msg = MakeWarn("", "", "UNKNOWN_THING", "Something happened")
+	line, _ = msg.Line()
+	assertEqual(line, "WARN * UNKNOWN_THING :Something happened\r\n")
+
+	msg = MakeNote("irc.example.com", "CHATHISTORY", "LIMITED", "Results were limited")
+	assertEqual(msg.Command, "NOTE")
+	assertEqual(msg.Params, []string{"CHATHISTORY", "LIMITED", "Results were limited"})
+}
+
+func TestEscapeISupportValue(t *testing.T) {
+	assertEqual(EscapeISupportValue(""), "")
+	assertEqual(EscapeISupportValue("Example"), "Example")
+	assertEqual(EscapeISupportValue("Example Net"), `Example\x20Net`)
+	assertEqual(EscapeISupportValue(`a=b\c`), `a\x3Db\x5Cc`)
+	assertEqual(EscapeISupportValue("a\x01"), `a\x01`)
+	assertEqual(EscapeISupportValue("ş"), "ş")
+
+	for _, value := range []string{"", "a", " ", "a b ", `\x20`, "x=y", "\\", "ş ş", "\x7f\x10"} {
+		assertEqual(UnescapeISupportValue(EscapeISupportValue(value)), value)
+	}
+	assertEqual(UnescapeISupportValue(`\xc5\x9f`), "ş")
+	assertEqual(UnescapeISupportValue(`\xzz\x2`), `\xzz\x2`)
+}
+
+func TestMakeISupport(t *testing.T) {
+	msgs, err := MakeISupport("irc.example.com", "dan", []ISupportToken{
+		{Name: "NETWORK", Value: "Example Net"},
+		{Name: "SAFELIST"},
+		{Name: "EXCEPTS", Negate: true},
+	}, 0)
+	assertEqual(err, nil)
+	assertEqual(len(msgs), 1)
+	line, _ := msgs[0].Line()
+	assertEqual(line, ":irc.example.com 005 dan NETWORK=Example\\x20Net SAFELIST -EXCEPTS :are supported by this server\r\n")
+
+	// at most 13 tokens per line:
+	var tokens []ISupportToken
+	for i := 0; i < 30; i++ {
+		tokens = append(tokens, ISupportToken{Name: fmt.Sprintf("T%d", i)})
+	}
+	msgs, err = MakeISupport("irc.example.com", "dan", tokens, 0)
+	assertEqual(err, nil)
+	assertEqual(len(msgs), 3)
+	assertEqual(len(msgs[0].Params), 15)
+	assertEqual(msgs[2].Params, []string{"dan", "T26", "T27", "T28", "T29", isupportDescription})
+
+	// splitting by length:
+	tokens = nil
+	for i := 0; i < 10; i++ {
+		tokens = append(tokens, ISupportToken{Name: fmt.Sprintf("TOKEN%d", i), Value: strings.Repeat("x", 40)})
+	}
+	msgs, err = MakeISupport("irc.example.com", "dan", tokens, 0)
+	assertEqual(err, nil)
+	assertEqual(len(msgs), 2)
+	var count int
+	for _, msg := range msgs {
+		line, err := msg.Line()
+		assertEqual(err, nil)
+		assertEqual(len(line) <= MaxlenLine, true)
+		parsed, err := ParseLine(line)
+		assertEqual(err, nil)
+		count += len(parsed.Params) - 2
+	}
+	assertEqual(count, 10)
+
+	_, err = MakeISupport("irc.example.com", "dan", []ISupportToken{{Name: "HUGE", Value: strings.Repeat("x", 500)}}, 0)
+	assertEqual(err, ErrorISupportTokenTooLong)
+
+	msgs, err = MakeISupport("irc.example.com", "dan", nil, 0)
+	assertEqual(err, nil)
+	assertEqual(len(msgs), 0)
+}
@@ -0,0 +1,37 @@
+package ircmsg
+
+import (
+	"time"
+)
+
+const (
+	// ServerTimeFormat is the format of the IRCv3 server-time tag
+	// (RFC 3339 in UTC, with millisecond precision), e.g. 2011-10-19T16:40:51.620Z
+	ServerTimeFormat = "2006-01-02T15:04:05.000Z"
+)
+
+// ParseServerTime parses the value of a server-time tag. Any RFC 3339
+// timestamp is accepted; the result is in UTC.
+func ParseServerTime(value string) (result time.Time, err error) {
+	result, err = time.Parse(time.RFC3339Nano, value)
+	if err == nil {
+		result = result.UTC()
+	}
+	return
+}
+
+// FormatServerTime formats a time as the value of a server-time tag.
+func FormatServerTime(t time.Time) string {
+	return t.UTC().Format(ServerTimeFormat)
+}
+
+// ServerTime returns the time at which the server processed the message,
+// according to its server-time tag; ok is false if the tag is absent or invalid.
+func (msg *Message) ServerTime() (serverTime time.Time, ok bool) {
+	present, value := msg.GetTag("time")
+	if !present {
+		return
+	}
+	serverTime, err := ParseServerTime(value)
+	return serverTime, err == nil
+}
@@ -0,0 +1,34 @@
+package ircmsg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServerTime(t *testing.T) {
+	expected := time.Date(2011, 10, 19, 16, 40, 51, 620000000, time.UTC)
+
+	msg, _ := ParseLine("@time=2011-10-19T16:40:51.620Z :nick!user@host PRIVMSG #chan :hi")
+	serverTime, ok := msg.ServerTime()
+	assertEqual(ok, true)
+	assertEqual(serverTime, expected)
+
+	// other RFC 3339 forms are accepted, and converted to UTC:
+	serverTime, err := ParseServerTime("2011-10-19T18:40:51.62+02:00")
+	assertEqual(err, nil)
+	assertEqual(serverTime, expected)
+	serverTime, err = ParseServerTime("2011-10-19T16:40:51Z")
+	assertEqual(err, nil)
+	assertEqual(serverTime, expected.Truncate(time.Second))
+
+	msg, _ = ParseLine("@time=yesterday PRIVMSG #chan :hi")
+	_, ok = msg.ServerTime()
+	assertEqual(ok, false)
+	msg, _ = ParseLine("PRIVMSG #chan :hi")
+	_, ok = msg.ServerTime()
+	assertEqual(ok, false)
+
+	assertEqual(FormatServerTime(expected), "2011-10-19T16:40:51.620Z")
+	assertEqual(FormatServerTime(expected.Add(123456)), "2011-10-19T16:40:51.620Z")
+	assertEqual(FormatServerTime(expected.Truncate(time.Second).In(time.FixedZone("UTC-5", -5*3600))), "2011-10-19T16:40:51.000Z")
+}
@@ -0,0 +1,101 @@
+package ircutils
+
+import (
+	"strings"
+)
+
+// Casemapping identifies one of the casemappings that can be advertised by
+// the server in the CASEMAPPING token of RPL_ISUPPORT; it determines which
+// names (nicknames, channel names, and so on) are considered equivalent.
+type Casemapping uint
+
+const (
+	// CasemappingRFC1459 is the default IRC casemapping: in addition to the
+	// ASCII letters, []\^ are considered the uppercase versions of {}|~.
+	CasemappingRFC1459 Casemapping = iota
+	// CasemappingASCII considers only the ASCII letters A-Z and a-z.
+	CasemappingASCII
+	// CasemappingRFC1459Strict is CasemappingRFC1459, except that ^ and ~
+	// are considered distinct.
+	CasemappingRFC1459Strict
+)
+
+// ParseCasemapping returns the Casemapping corresponding to the value of
+// the CASEMAPPING ISUPPORT token. Unknown values (including the empty string,
+// i.e., the token was not sent) are treated as CasemappingRFC1459.
+func ParseCasemapping(token string) Casemapping {
+	switch strings.ToLower(token) {
+	case "ascii":
+		return CasemappingASCII
+	case "rfc1459-strict", "strict-rfc1459":
+		return CasemappingRFC1459Strict
+	default:
+		return CasemappingRFC1459
+	}
+}
+
+// String returns the name of the casemapping, as used in ISUPPORT.
+func (cm Casemapping) String() string {
+	switch cm {
+	case CasemappingASCII:
+		return "ascii"
+	case CasemappingRFC1459Strict:
+		return "rfc1459-strict"
+	default:
+		return "rfc1459"
+	}
+}
+
+// Fold casefolds a name according to the casemapping, so that two names
+// are equivalent if and only if their folded forms are equal.
+// Non-ASCII bytes are left unchanged.
+func (cm Casemapping) Fold(name string) string {
+	var buf []byte
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		folded := cm.foldByte(c)
+		if folded != c && buf == nil {
+			// slowpath: we have to modify the string
+			buf = make([]byte, len(name))
+			copy(buf, name[:i])
+		}
+		if buf != nil {
+			buf[i] = folded
+		}
+	}
+	if buf == nil {
+		return name
+	}
+	return string(buf)
+}
+
+// Equal returns whether two names are equivalent under the casemapping.
+func (cm Casemapping) Equal(a, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		if cm.foldByte(a[i]) != cm.foldByte(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (cm Casemapping) foldByte(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + ('a' - 'A')
+	}
+	if cm == CasemappingASCII {
+		return c
+	}
+	switch c {
+	case '[', ']', '\\':
+		return c + ('{' - '[')
+	case '^':
+		if cm == CasemappingRFC1459 {
+			return '~'
+		}
+	}
+	return c
+}
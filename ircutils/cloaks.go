@@ -0,0 +1,146 @@
+package ircutils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+)
+
+var (
+	ErrInvalidCloakConfig = errors.New("invalid cloak configuration")
+)
+
+const (
+	defaultCloakSuffix        = "irc"
+	defaultCloakCidrLenIPv4   = 32
+	defaultCloakCidrLenIPv6   = 64
+	defaultCloakSegmentLength = 8
+	maxCloakSegments          = 8
+)
+
+var (
+	cloakEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+)
+
+// CloakConfig generates deterministic, keyed cloaks, i.e. hostnames that
+// conceal a client's real IP address or hostname, but are always the same
+// for the same (masked) address. Cloaks consist of one or more segments of
+// an HMAC of the address, followed by a suffix, e.g. "ab3kx2fe.qz7cm4na.irc".
+// They always satisfy HostnameIsValid. Zero-valued fields take their default
+// values; call Validate before use.
+type CloakConfig struct {
+	// Secret is the HMAC key; it must be kept private, and changing it
+	// changes every cloak.
+	Secret []byte
+	// Suffix is appended to every IP cloak (default "irc").
+	Suffix string
+	// CidrLenIPv4 and CidrLenIPv6 are the netmask bits: addresses in the same
+	// network (of this size) receive the same cloak (defaults 32 and 64).
+	CidrLenIPv4 int
+	CidrLenIPv6 int
+	// Segments is the number of hashed segments (default 1, maximum 8).
+	Segments int
+	// SegmentLength is the length of each hashed segment (default 8).
+	SegmentLength int
+	// KeepHostnameLabels is the number of trailing labels of a real hostname
+	// to retain in its cloak, e.g. 2 would cloak "host-1.isp.example.com"
+	// to "ab3kx2fe.example.com". If zero, hostname cloaks use Suffix instead.
+	KeepHostnameLabels int
+}
+
+// Validate checks the configuration, filling in default values.
+func (config *CloakConfig) Validate() error {
+	if len(config.Secret) == 0 {
+		return ErrInvalidCloakConfig
+	}
+	if config.Suffix == "" {
+		config.Suffix = defaultCloakSuffix
+	}
+	suffix, err := ValidateHostname(config.Suffix, &HostnameOptions{AllowSingleLabel: true})
+	if err != nil {
+		return ErrInvalidCloakConfig
+	}
+	config.Suffix = suffix
+	if config.CidrLenIPv4 == 0 {
+		config.CidrLenIPv4 = defaultCloakCidrLenIPv4
+	}
+	if config.CidrLenIPv6 == 0 {
+		config.CidrLenIPv6 = defaultCloakCidrLenIPv6
+	}
+	if config.Segments == 0 {
+		config.Segments = 1
+	}
+	if config.SegmentLength == 0 {
+		config.SegmentLength = defaultCloakSegmentLength
+	}
+	if config.CidrLenIPv4 < 0 || config.CidrLenIPv4 > 32 || config.CidrLenIPv6 < 0 || config.CidrLenIPv6 > 128 ||
+		config.Segments < 0 || config.Segments > maxCloakSegments ||
+		config.SegmentLength < 0 || config.SegmentLength > 63 || config.KeepHostnameLabels < 0 {
+		return ErrInvalidCloakConfig
+	}
+	if !HostnameIsValid(config.hashSegments("", nil) + "." + config.Suffix) {
+		// the result would be too long
+		return ErrInvalidCloakConfig
+	}
+	return nil
+}
+
+// CloakIP returns the cloak for an IP address. IPv4-mapped IPv6 addresses
+// are treated as IPv4.
+func (config *CloakConfig) CloakIP(ip net.IP) string {
+	var domain string
+	if ip4 := ip.To4(); ip4 != nil {
+		domain = "ip4"
+		ip = ip4.Mask(net.CIDRMask(config.CidrLenIPv4, 32))
+	} else {
+		domain = "ip6"
+		ip = ip.To16().Mask(net.CIDRMask(config.CidrLenIPv6, 128))
+	}
+	return config.hashSegments(domain, ip) + "." + config.Suffix
+}
+
+// CloakHostname returns the cloak for a hostname (which is compared
+// case-insensitively).
+func (config *CloakConfig) CloakHostname(hostname string) string {
+	hostname = strings.ToLower(hostname)
+	hashed := config.hashSegments("host", []byte(hostname))
+	if config.KeepHostnameLabels != 0 {
+		labels := strings.Split(hostname, ".")
+		// only keep labels if at least one is being concealed:
+		if len(labels) > config.KeepHostnameLabels {
+			kept := strings.Join(labels[len(labels)-config.KeepHostnameLabels:], ".")
+			if cloak := hashed + "." + kept; HostnameIsValid(cloak) {
+				return cloak
+			}
+		}
+	}
+	return hashed + "." + config.Suffix
+}
+
+// hashSegments returns the dot-separated hashed segments for an input
+// in a given domain (so that e.g. an IP and a hostname can't collide).
+func (config *CloakConfig) hashSegments(domain string, input []byte) string {
+	needed := config.Segments * config.SegmentLength
+	var encoded strings.Builder
+	var counter [4]byte
+	for i := uint32(0); encoded.Len() < needed; i++ {
+		// expand the HMAC output as needed, in the style of HKDF
+		mac := hmac.New(sha256.New, config.Secret)
+		binary.BigEndian.PutUint32(counter[:], i)
+		mac.Write(counter[:])
+		mac.Write([]byte(domain))
+		mac.Write([]byte{0})
+		mac.Write(input)
+		encoded.WriteString(cloakEncoding.EncodeToString(mac.Sum(nil)))
+	}
+	hash := encoded.String()
+	segments := make([]string, config.Segments)
+	for i := range segments {
+		segments[i] = hash[i*config.SegmentLength : (i+1)*config.SegmentLength]
+	}
+	return strings.Join(segments, ".")
+}
@@ -0,0 +1,72 @@
+package ircutils
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestCloakConfig(t *testing.T) {
+	var config CloakConfig
+	assertEqual(config.Validate(), ErrInvalidCloakConfig)
+	config.Secret = []byte("sesame")
+	assertEqual(config.Validate(), nil)
+	assertEqual(config, CloakConfig{Secret: []byte("sesame"), Suffix: "irc", CidrLenIPv4: 32, CidrLenIPv6: 64, Segments: 1, SegmentLength: 8})
+
+	for _, invalid := range []CloakConfig{
+		{Secret: []byte("x"), Suffix: "bad suffix"},
+		{Secret: []byte("x"), CidrLenIPv4: 33},
+		{Secret: []byte("x"), CidrLenIPv6: -1},
+		{Secret: []byte("x"), Segments: 9},
+		{Secret: []byte("x"), SegmentLength: 64},
+		{Secret: []byte("x"), Segments: 4, SegmentLength: 50},
+	} {
+		assertEqual(invalid.Validate(), ErrInvalidCloakConfig)
+	}
+}
+
+func TestCloakIP(t *testing.T) {
+	config := CloakConfig{Secret: []byte("sesame"), Suffix: "Users.Example", CidrLenIPv4: 24, Segments: 3, SegmentLength: 5}
+	assertEqual(config.Validate(), nil)
+
+	cloak := config.CloakIP(net.ParseIP("192.0.2.1"))
+	assertEqual(HostnameIsValid(cloak), true)
+	assertEqual(strings.HasSuffix(cloak, ".users.example"), true)
+	assertEqual(len(strings.Split(cloak, ".")), 5)
+	assertEqual(len(cloak), len("abcde.abcde.abcde.users.example"))
+
+	// deterministic, and masked:
+	assertEqual(config.CloakIP(net.ParseIP("192.0.2.1")), cloak)
+	assertEqual(config.CloakIP(net.ParseIP("192.0.2.200")), cloak)
+	assertEqual(config.CloakIP(net.ParseIP("::ffff:192.0.2.7")), cloak)
+	assertEqual(config.CloakIP(net.ParseIP("192.0.3.1")) == cloak, false)
+
+	v6 := config.CloakIP(net.ParseIP("2001:db8::1"))
+	assertEqual(HostnameIsValid(v6), true)
+	assertEqual(config.CloakIP(net.ParseIP("2001:db8::ffff:1")), v6)
+	assertEqual(config.CloakIP(net.ParseIP("2001:db8:0:1::1")) == v6, false)
+
+	// keyed:
+	other := config
+	other.Secret = []byte("open sesame")
+	assertEqual(other.CloakIP(net.ParseIP("192.0.2.1")) == cloak, false)
+}
+
+func TestCloakHostname(t *testing.T) {
+	config := CloakConfig{Secret: []byte("sesame")}
+	assertEqual(config.Validate(), nil)
+	cloak := config.CloakHostname("host-1.isp.example.com")
+	assertEqual(HostnameIsValid(cloak), true)
+	assertEqual(strings.HasSuffix(cloak, ".irc"), true)
+	assertEqual(config.CloakHostname("HOST-1.isp.example.com"), cloak)
+	// hostnames and IPs are hashed separately:
+	assertEqual(config.CloakHostname("192.0.2.1") == config.CloakIP(net.ParseIP("192.0.2.1")), false)
+
+	config.KeepHostnameLabels = 2
+	cloak = config.CloakHostname("host-1.isp.example.com")
+	assertEqual(HostnameIsValid(cloak), true)
+	assertEqual(strings.HasSuffix(cloak, ".example.com"), true)
+	assertEqual(len(cloak), len("abcdefgh.example.com"))
+	// nothing would be concealed:
+	assertEqual(strings.HasSuffix(config.CloakHostname("example.com"), ".irc"), true)
+}
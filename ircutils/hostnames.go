@@ -3,7 +3,13 @@
 
 package ircutils
 
-import "strings"
+import (
+	"errors"
+	"net"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
 
 var allowedHostnameChars = "abcdefghijklmnopqrstuvwxyz1234567890-."
 
@@ -16,7 +22,8 @@ var allowedHostnameChars = "abcdefghijklmnopqrstuvwxyz1234567890-."
 //
 // In addition to this function, servers should impose their own limits on max
 // hostname length -- this function limits it to 200 but most servers will probably
-// want to make it smaller than that.
+// want to make it smaller than that. For internationalized hostnames, IP
+// literals or a different length limit, see ValidateHostname.
 func HostnameIsValid(hostname string) bool {
 	// IRC hostnames specifically require a period, rough limit of 200 chars
 	if !strings.Contains(hostname, ".") || len(hostname) < 1 || len(hostname) > 200 {
@@ -39,3 +46,157 @@ func HostnameIsValid(hostname string) bool {
 
 	return true
 }
+
+var (
+	ErrHostnameEmpty       = errors.New("hostname is empty")
+	ErrHostnameTooLong     = errors.New("hostname is too long")
+	ErrHostnameNoDot       = errors.New("hostname does not contain a period")
+	ErrHostnameLabel       = errors.New("hostname contains an empty or overlong label")
+	ErrHostnameHyphen      = errors.New("hostname label begins or ends with a hyphen")
+	ErrHostnameInvalidChar = errors.New("hostname contains an invalid character")
+	ErrHostnameIDN         = errors.New("hostname contains an invalid internationalized label")
+)
+
+const (
+	// the maximum length of a DNS name in its textual form
+	DefaultHostnameMaxLength = 253
+)
+
+// HostnameOptions controls the behavior of ValidateHostname.
+type HostnameOptions struct {
+	// MaxLength is the maximum length of the hostname, in its ASCII form
+	// (default DefaultHostnameMaxLength).
+	MaxLength int
+	// AllowIDN accepts internationalized hostnames, either as Unicode
+	// or as punycode ("xn--") labels.
+	AllowIDN bool
+	// AllowIPLiterals accepts IPv4 and IPv6 address literals, e.g. for
+	// clients whose IP address has no reverse DNS.
+	AllowIPLiterals bool
+	// AllowSingleLabel accepts hostnames without a period, e.g. "localhost".
+	AllowSingleLabel bool
+}
+
+// ValidateHostname is a configurable variant of HostnameIsValid. If the
+// hostname is valid, it returns the hostname in a normalized ASCII form that
+// is safe to use on IRC: lowercased, with Unicode labels converted to
+// punycode, and IPv6 literals in canonical form (without brackets, and with a
+// leading zero if they would otherwise begin with a colon). Otherwise, it
+// returns one of the ErrHostname errors. A nil options uses the defaults.
+func ValidateHostname(hostname string, options *HostnameOptions) (result string, err error) {
+	if options == nil {
+		options = &HostnameOptions{}
+	}
+	if hostname == "" {
+		return "", ErrHostnameEmpty
+	}
+	maxLength := options.MaxLength
+	if maxLength <= 0 {
+		maxLength = DefaultHostnameMaxLength
+	}
+
+	if options.AllowIPLiterals && strings.IndexByte(hostname, ':') != -1 {
+		literal := hostname
+		if strings.HasPrefix(literal, "[") && strings.HasSuffix(literal, "]") {
+			literal = literal[1 : len(literal)-1]
+		}
+		ip := net.ParseIP(literal)
+		if ip == nil {
+			return "", ErrHostnameInvalidChar
+		}
+		// (IPv4-mapped addresses are displayed as IPv4)
+		result = ip.String()
+		if strings.HasPrefix(result, ":") {
+			result = "0" + result
+		}
+		return result, nil
+	}
+
+	labels := strings.Split(hostname, ".")
+	if len(labels) < 2 && !options.AllowSingleLabel {
+		return "", ErrHostnameNoDot
+	}
+	for i, label := range labels {
+		if labels[i], err = validateHostnameLabel(label, options.AllowIDN); err != nil {
+			return "", err
+		}
+	}
+	result = strings.Join(labels, ".")
+	if len(result) > maxLength {
+		return "", ErrHostnameTooLong
+	}
+	return result, nil
+}
+
+func validateHostnameLabel(label string, allowIDN bool) (result string, err error) {
+	ascii := true
+	for i := 0; i < len(label); i++ {
+		if label[i] >= utf8.RuneSelf {
+			ascii = false
+			break
+		}
+	}
+
+	if !ascii {
+		if !allowIDN {
+			return "", ErrHostnameInvalidChar
+		}
+		if !utf8.ValidString(label) {
+			return "", ErrHostnameIDN
+		}
+		// approximate the IDNA mapping by lowercasing
+		label = strings.ToLower(label)
+		if err = checkIDNLabel(label); err != nil {
+			return "", err
+		}
+		label = "xn--" + punycodeEncode(label)
+	} else {
+		label = strings.ToLower(label)
+		if strings.HasPrefix(label, "xn--") {
+			if !allowIDN {
+				return "", ErrHostnameIDN
+			}
+			// the label must be the canonical encoding of a valid Unicode label
+			decoded, err := punycodeDecode(label[4:])
+			if err != nil || checkIDNLabel(decoded) != nil || decoded != strings.ToLower(decoded) ||
+				"xn--"+punycodeEncode(decoded) != label {
+				return "", ErrHostnameIDN
+			}
+		}
+	}
+
+	if len(label) < 1 || len(label) > 63 {
+		return "", ErrHostnameLabel
+	}
+	if strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+		return "", ErrHostnameHyphen
+	}
+	for i := 0; i < len(label); i++ {
+		if strings.IndexByte(allowedHostnameChars, label[i]) == -1 {
+			return "", ErrHostnameInvalidChar
+		}
+	}
+	return label, nil
+}
+
+// checkIDNLabel checks the characters of a Unicode label: only letters,
+// combining marks, digits and hyphens are allowed, and the label must contain
+// at least one non-ASCII character.
+func checkIDNLabel(label string) error {
+	ascii := true
+	for i, r := range label {
+		if r >= utf8.RuneSelf {
+			ascii = false
+		}
+		if i == 0 && unicode.IsMark(r) {
+			return ErrHostnameIDN
+		}
+		if !(r == '-' || unicode.IsLetter(r) || unicode.IsMark(r) || unicode.IsDigit(r)) {
+			return ErrHostnameInvalidChar
+		}
+	}
+	if ascii {
+		return ErrHostnameIDN
+	}
+	return nil
+}
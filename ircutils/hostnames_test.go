@@ -0,0 +1,99 @@
+package ircutils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHostnameIsValid(t *testing.T) {
+	assertEqual(HostnameIsValid("irc.example.com"), true)
+	assertEqual(HostnameIsValid("IRC.example.com"), true)
+	assertEqual(HostnameIsValid("localhost"), false)
+	assertEqual(HostnameIsValid("bücher.example"), false)
+	assertEqual(HostnameIsValid("-irc.example.com"), false)
+	assertEqual(HostnameIsValid("2001:db8::1"), false)
+	assertEqual(HostnameIsValid(strings.Repeat("a.", 100)+"com"), false)
+}
+
+func TestPunycode(t *testing.T) {
+	// examples from RFC 3492, section 7.1, and elsewhere:
+	pairs := [][2]string{
+		{"bücher", "bcher-kva"},
+		{"münchen", "mnchen-3ya"},
+		{"ليهمابتكلموشعربي؟", "egbpdaj6bu4bxfgehfvwxn"},
+		{"他们为什么不说中文", "ihqwcrb4cv8a8dqg056pqjye"},
+		{"3年b組金八先生", "3b-ww4c5e180e575a65lsy2b"},
+		{"правильно", "80aesmkgbi6i"},
+		{"-> $1.00 <-", "-> $1.00 <--"},
+	}
+	for _, pair := range pairs {
+		assertEqual(punycodeEncode(pair[0]), pair[1])
+		decoded, err := punycodeDecode(pair[1])
+		assertEqual(err, nil)
+		assertEqual(decoded, pair[0])
+	}
+	for _, invalid := range []string{"bcher-kv!", "99999999999999", "a-" + strings.Repeat("9", 62)} {
+		_, err := punycodeDecode(invalid)
+		assertEqual(err, errInvalidPunycode)
+	}
+}
+
+func TestValidateHostname(t *testing.T) {
+	check := func(hostname string, options HostnameOptions, expected string, expectedErr error) {
+		result, err := ValidateHostname(hostname, &options)
+		if result != expected || err != expectedErr {
+			t.Errorf("ValidateHostname(%q, %#v): expected %q, %v; got %q, %v", hostname, options, expected, expectedErr, result, err)
+		}
+	}
+
+	// the defaults are similar to HostnameIsValid:
+	check("IRC.example.com", HostnameOptions{}, "irc.example.com", nil)
+	check("", HostnameOptions{}, "", ErrHostnameEmpty)
+	check("localhost", HostnameOptions{}, "", ErrHostnameNoDot)
+	check("localhost", HostnameOptions{AllowSingleLabel: true}, "localhost", nil)
+	check("irc..example.com", HostnameOptions{}, "", ErrHostnameLabel)
+	check("irc.example.com.", HostnameOptions{}, "", ErrHostnameLabel)
+	check(strings.Repeat("a", 64)+".com", HostnameOptions{}, "", ErrHostnameLabel)
+	check("irc-.example.com", HostnameOptions{}, "", ErrHostnameHyphen)
+	check("irc_1.example.com", HostnameOptions{}, "", ErrHostnameInvalidChar)
+	check("bücher.example", HostnameOptions{}, "", ErrHostnameInvalidChar)
+	check("xn--bcher-kva.example", HostnameOptions{}, "", ErrHostnameIDN)
+
+	// nil options are the defaults:
+	result, err := ValidateHostname("IRC.example.com", nil)
+	assertEqual(result, "irc.example.com")
+	assertEqual(err, nil)
+	_, err = ValidateHostname("localhost", nil)
+	assertEqual(err, ErrHostnameNoDot)
+
+	// length:
+	long := strings.Repeat("a.", 110) + "com"
+	check(long, HostnameOptions{}, long, nil)
+	check(long, HostnameOptions{MaxLength: 200}, "", ErrHostnameTooLong)
+	check("irc.example.com", HostnameOptions{MaxLength: 10}, "", ErrHostnameTooLong)
+
+	// IDN:
+	idn := HostnameOptions{AllowIDN: true}
+	check("Bücher.example", idn, "xn--bcher-kva.example", nil)
+	check("XN--BCHER-KVA.example", idn, "xn--bcher-kva.example", nil)
+	check("правильно.рф", idn, "xn--80aesmkgbi6i.xn--p1ai", nil)
+	check("xn--abc.example", idn, "", ErrHostnameIDN)
+	check("xn--bcher.example", idn, "", ErrHostnameIDN)
+	check("bü cher.example", idn, "", ErrHostnameInvalidChar)
+	check("bü\xffcher.example", idn, "", ErrHostnameIDN)
+	check("ü"+strings.Repeat("a", 60)+".example", idn, "", ErrHostnameLabel)
+
+	// IP literals:
+	ip := HostnameOptions{AllowIPLiterals: true}
+	check("2001:DB8:0::1", ip, "2001:db8::1", nil)
+	check("[2001:db8::1]", ip, "2001:db8::1", nil)
+	check("::1", ip, "0::1", nil)
+	check("0::1", ip, "0::1", nil)
+	check("::ffff:192.0.2.1", ip, "192.0.2.1", nil)
+	check("2001:db8::g", ip, "", ErrHostnameInvalidChar)
+	check("::ffff:192.0.2.1", HostnameOptions{}, "", ErrHostnameInvalidChar)
+	check("192.0.2.1", HostnameOptions{}, "192.0.2.1", nil)
+	// IPv6 reverse DNS:
+	check("1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa", HostnameOptions{},
+		"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa", nil)
+}
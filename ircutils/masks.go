@@ -0,0 +1,474 @@
+package ircutils
+
+import (
+	"errors"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+var (
+	ErrInvalidMask   = errors.New("invalid mask")
+	ErrUnknownExtban = errors.New("unknown or unsupported extban type")
+)
+
+// CompileGlob compiles an IRC glob (in which * matches any sequence of
+// characters, including the empty sequence, and ? matches any single
+// character) to a regular expression. If submatch is true, the expression
+// matches any string containing a match for the glob; otherwise it must
+// match the entire string.
+func CompileGlob(glob string, submatch bool) (*regexp.Regexp, error) {
+	var buf strings.Builder
+	buf.WriteString("(?s)")
+	if !submatch {
+		buf.WriteByte('^')
+	}
+	addGlobRegexp(&buf, glob)
+	if !submatch {
+		buf.WriteByte('$')
+	}
+	return regexp.Compile(buf.String())
+}
+
+func addGlobRegexp(buf *strings.Builder, glob string) {
+	for _, r := range glob {
+		switch r {
+		case '*':
+			buf.WriteString(".*")
+		case '?':
+			buf.WriteString(".")
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+}
+
+// CanonicalizeMask expands a partial nick!user@host mask to the full form,
+// e.g., "dan" to "dan!*@*" and "*@example.com" to "*!*@example.com".
+func CanonicalizeMask(mask string) string {
+	var nick, user, host string
+	bangIndex := strings.IndexByte(mask, '!')
+	atIndex := strings.LastIndexByte(mask, '@')
+	if atIndex != -1 && atIndex < bangIndex {
+		// the @ belongs to the username, e.g. "a!b@c!d"; take it as literal
+		atIndex = -1
+	}
+	if atIndex == -1 {
+		host = "*"
+	} else {
+		host = mask[atIndex+1:]
+		mask = mask[:atIndex]
+	}
+	if bangIndex == -1 {
+		user = "*"
+		nick = mask
+	} else {
+		nick, user = mask[:bangIndex], mask[bangIndex+1:]
+	}
+	if nick == "" {
+		nick = "*"
+	}
+	if user == "" {
+		user = "*"
+	}
+	if host == "" {
+		host = "*"
+	}
+	return nick + "!" + user + "@" + host
+}
+
+// CompileMask compiles a nick!user@host mask (which will first be
+// canonicalized with CanonicalizeMask) into a regular expression that can be
+// matched against sources casefolded with the given casemapping.
+func CompileMask(mask string, casemapping Casemapping) (*regexp.Regexp, error) {
+	return CompileGlob(casemapping.Fold(CanonicalizeMask(mask)), false)
+}
+
+// MatchMask returns whether a nick!user@host mask matches the given source.
+// For matching a source against many masks, use a MaskSet.
+func MatchMask(mask string, nuh ircmsg.NUH, casemapping Casemapping) bool {
+	re, err := CompileMask(mask, casemapping)
+	if err != nil {
+		return false
+	}
+	return re.MatchString(foldedNUH(nuh, casemapping))
+}
+
+func foldedNUH(nuh ircmsg.NUH, casemapping Casemapping) string {
+	return casemapping.Fold(nuh.Name + "!" + nuh.User + "@" + nuh.Host)
+}
+
+// MatchGlob returns whether str matches an IRC glob (in which * matches any
+// sequence of characters, including the empty sequence, and ? matches any
+// single character). Unlike CompileGlob, it does not allocate.
+func MatchGlob(glob, str string) bool {
+	gi, si := 0, 0
+	// position of the last * seen, and of the input that it has consumed up to
+	starGi, starSi := -1, 0
+	for si < len(str) {
+		if gi < len(glob) {
+			switch glob[gi] {
+			case '*':
+				starGi, starSi = gi, si
+				gi++
+				continue
+			case '?':
+				_, n := utf8.DecodeRuneInString(str[si:])
+				gi, si = gi+1, si+n
+				continue
+			default:
+				if glob[gi] == str[si] {
+					gi, si = gi+1, si+1
+					continue
+				}
+			}
+		}
+		if starGi == -1 {
+			return false
+		}
+		// backtrack: the last * consumes one more character
+		_, n := utf8.DecodeRuneInString(str[starSi:])
+		starSi += n
+		gi, si = starGi+1, starSi
+	}
+	for gi < len(glob) && glob[gi] == '*' {
+		gi++
+	}
+	return gi == len(glob)
+}
+
+func hasWildcard(glob string) bool {
+	return strings.IndexByte(glob, '*') != -1 || strings.IndexByte(glob, '?') != -1
+}
+
+// MaskTarget contains the information about a client that can be matched
+// against the masks in a MaskSet.
+type MaskTarget struct {
+	ircmsg.NUH
+	// IP is the IP address of the client, if known; if it is nil but
+	// NUH.Host is an IP address, that address is used for matching CIDR masks.
+	IP net.IP
+	// Account is the client's account name, or the empty string if the
+	// client is not logged in.
+	Account string
+	// RealName is the client's realname (gecos).
+	RealName string
+}
+
+type globEntry struct {
+	mask string // as originally added
+	glob string // casefolded and canonicalized
+}
+
+type extbanEntry struct {
+	mask    string
+	negated bool
+	kind    byte
+	glob    string // casefolded; empty for a bare $a
+}
+
+// MaskSet is a set of masks that can be efficiently matched against clients,
+// e.g., a channel ban list or a server-wide list of k-lines. It supports:
+//
+//  1. nick!user@host masks with the * and ? wildcards (partial masks are
+//     expanded as per CanonicalizeMask)
+//  2. masks whose host is in CIDR notation, e.g. *!*@192.0.2.0/24, which match
+//     clients whose IP address is in the network
+//  3. extbans (with the prefix $, optionally followed by ~ to negate):
+//     $a matches any logged-in client, $a:<glob> matches the account name,
+//     and $r:<glob> matches the realname
+//
+// Masks with a literal nickname, a literal hostname, or a hostname of the
+// form *<literal> (e.g. *!*@*.example.com) are indexed, so that matching
+// does not need to test them individually.
+//
+// A MaskSet is safe for concurrent use.
+type MaskSet struct {
+	mu          sync.Mutex
+	casemapping Casemapping
+
+	masks map[string]struct{} // all masks, as originally added
+
+	byNick       map[string][]globEntry // masks with no wildcards in the nickname
+	byHost       map[string][]globEntry // masks with no wildcards in the hostname
+	byHostSuffix map[string][]globEntry // masks whose hostname is * followed by a literal
+	otherGlobs   []globEntry
+	// CIDR masks, indexed by prefix length (of the 16-byte IP) and then by
+	// the masked network address:
+	cidrs   map[int]map[string][]globEntry
+	extbans []extbanEntry
+}
+
+// NewMaskSet returns a new, empty MaskSet, which will compare names
+// using the given casemapping.
+func NewMaskSet(casemapping Casemapping) *MaskSet {
+	result := new(MaskSet)
+	result.Initialize(casemapping)
+	return result
+}
+
+// Initialize initializes a MaskSet in place, removing all masks.
+func (s *MaskSet) Initialize(casemapping Casemapping) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.casemapping = casemapping
+	s.masks = make(map[string]struct{})
+	s.byNick = make(map[string][]globEntry)
+	s.byHost = make(map[string][]globEntry)
+	s.byHostSuffix = make(map[string][]globEntry)
+	s.otherGlobs = nil
+	s.cidrs = make(map[int]map[string][]globEntry)
+	s.extbans = nil
+}
+
+// Add adds a mask to the set, returning an error if the mask is invalid.
+func (s *MaskSet) Add(mask string) (err error) {
+	if mask == "" {
+		return ErrInvalidMask
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.masks[mask]; ok {
+		return nil
+	}
+
+	if mask[0] == '$' {
+		entry, err := s.parseExtban(mask)
+		if err != nil {
+			return err
+		}
+		s.extbans = append(s.extbans, entry)
+	} else if cidrs, key, entry, ok := s.cidrBucket(mask); ok {
+		cidrs[key] = append(cidrs[key], entry)
+	} else {
+		entry := globEntry{mask: mask, glob: s.casemapping.Fold(CanonicalizeMask(mask))}
+		if bucket, key := s.globBucket(entry.glob); bucket != nil {
+			bucket[key] = append(bucket[key], entry)
+		} else {
+			s.otherGlobs = append(s.otherGlobs, entry)
+		}
+	}
+	s.masks[mask] = struct{}{}
+	return nil
+}
+
+func (s *MaskSet) parseExtban(mask string) (entry extbanEntry, err error) {
+	entry.mask = mask
+	spec := mask[1:]
+	if strings.HasPrefix(spec, "~") {
+		entry.negated = true
+		spec = spec[1:]
+	}
+	if spec == "" {
+		return entry, ErrInvalidMask
+	}
+	entry.kind = spec[0]
+	arg := spec[1:]
+	switch entry.kind {
+	case 'a':
+		if arg == "" {
+			return entry, nil
+		}
+	case 'r':
+	default:
+		return entry, ErrUnknownExtban
+	}
+	if !strings.HasPrefix(arg, ":") || len(arg) == 1 {
+		return entry, ErrInvalidMask
+	}
+	entry.glob = s.casemapping.Fold(arg[1:])
+	return entry, nil
+}
+
+// globBucket returns the index (and key within it) for a canonicalized glob,
+// or nil if it cannot be indexed
+func (s *MaskSet) globBucket(glob string) (bucket map[string][]globEntry, key string) {
+	nick := glob[:strings.IndexByte(glob, '!')]
+	host := glob[strings.LastIndexByte(glob, '@')+1:]
+	if !hasWildcard(nick) {
+		return s.byNick, nick
+	} else if !hasWildcard(host) {
+		return s.byHost, host
+	} else if host[0] == '*' && !hasWildcard(host[1:]) {
+		return s.byHostSuffix, host[1:]
+	}
+	return nil, ""
+}
+
+// cidrBucket returns the index (and key within it) for a CIDR mask; ok is false
+// if the mask is not a CIDR mask. The glob of the returned entry is the nick!user.
+func (s *MaskSet) cidrBucket(mask string) (bucket map[string][]globEntry, key string, entry globEntry, ok bool) {
+	canonical := s.casemapping.Fold(CanonicalizeMask(mask))
+	atIndex := strings.LastIndexByte(canonical, '@')
+	_, network, err := net.ParseCIDR(canonical[atIndex+1:])
+	if err != nil {
+		return
+	}
+	ones, bits := network.Mask.Size()
+	if bits == 32 {
+		ones += 96
+	}
+	key = string(network.IP.To16().Mask(net.CIDRMask(ones, 128)))
+	if s.cidrs[ones] == nil {
+		s.cidrs[ones] = make(map[string][]globEntry)
+	}
+	return s.cidrs[ones], key, globEntry{mask: mask, glob: canonical[:atIndex]}, true
+}
+
+// Remove removes a mask from the set; it must be identical to the string
+// that was passed to Add.
+func (s *MaskSet) Remove(mask string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.masks[mask]; !ok {
+		return
+	}
+	delete(s.masks, mask)
+
+	if mask[0] == '$' {
+		for i, entry := range s.extbans {
+			if entry.mask == mask {
+				s.extbans = append(s.extbans[:i:i], s.extbans[i+1:]...)
+				break
+			}
+		}
+	} else if cidrs, key, _, ok := s.cidrBucket(mask); ok {
+		removeGlobEntry(cidrs, key, mask)
+		for ones, networks := range s.cidrs {
+			if len(networks) == 0 {
+				delete(s.cidrs, ones)
+			}
+		}
+	} else if bucket, key := s.globBucket(s.casemapping.Fold(CanonicalizeMask(mask))); bucket != nil {
+		removeGlobEntry(bucket, key, mask)
+	} else {
+		for i, entry := range s.otherGlobs {
+			if entry.mask == mask {
+				s.otherGlobs = append(s.otherGlobs[:i:i], s.otherGlobs[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+func removeGlobEntry(bucket map[string][]globEntry, key, mask string) {
+	entries := bucket[key]
+	for i, entry := range entries {
+		if entry.mask == mask {
+			entries = append(entries[:i:i], entries[i+1:]...)
+			break
+		}
+	}
+	if len(entries) == 0 {
+		delete(bucket, key)
+	} else {
+		bucket[key] = entries
+	}
+}
+
+// Masks returns all the masks in the set, in sorted order.
+func (s *MaskSet) Masks() (result []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result = make([]string, 0, len(s.masks))
+	for mask := range s.masks {
+		result = append(result, mask)
+	}
+	sort.Strings(result)
+	return
+}
+
+// Len returns the number of masks in the set.
+func (s *MaskSet) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.masks)
+}
+
+// Match returns whether any mask in the set matches the target.
+func (s *MaskSet) Match(target MaskTarget) bool {
+	_, matched := s.FindMatch(target)
+	return matched
+}
+
+// FindMatch returns a mask (as originally added) from the set that matches
+// the target, if any.
+func (s *MaskSet) FindMatch(target MaskTarget) (mask string, matched bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	folded := foldedNUH(target.NUH, s.casemapping)
+	nickUser := folded[:strings.LastIndexByte(folded, '@')]
+	nick := folded[:strings.IndexByte(folded, '!')]
+	host := folded[len(nickUser)+1:]
+
+	if mask, matched = matchGlobEntries(s.byNick[nick], folded); matched {
+		return
+	}
+	if mask, matched = matchGlobEntries(s.byHost[host], folded); matched {
+		return
+	}
+	for i := 0; i <= len(host); i++ {
+		if mask, matched = matchGlobEntries(s.byHostSuffix[host[i:]], folded); matched {
+			return
+		}
+	}
+	if mask, matched = matchGlobEntries(s.otherGlobs, folded); matched {
+		return
+	}
+
+	if len(s.cidrs) != 0 {
+		ip := target.IP
+		if ip == nil {
+			ip = net.ParseIP(target.Host)
+		}
+		if ip16 := ip.To16(); ip16 != nil {
+			for ones, networks := range s.cidrs {
+				key := string(ip16.Mask(net.CIDRMask(ones, 128)))
+				if mask, matched = matchGlobEntries(networks[key], nickUser); matched {
+					return
+				}
+			}
+		}
+	}
+
+	for _, entry := range s.extbans {
+		if entry.matches(target, s.casemapping) != entry.negated {
+			return entry.mask, true
+		}
+	}
+
+	return "", false
+}
+
+func matchGlobEntries(entries []globEntry, str string) (mask string, matched bool) {
+	for _, entry := range entries {
+		if MatchGlob(entry.glob, str) {
+			return entry.mask, true
+		}
+	}
+	return "", false
+}
+
+func (entry *extbanEntry) matches(target MaskTarget, casemapping Casemapping) bool {
+	switch entry.kind {
+	case 'a':
+		if target.Account == "" {
+			return false
+		}
+		return entry.glob == "" || MatchGlob(entry.glob, casemapping.Fold(target.Account))
+	case 'r':
+		return MatchGlob(entry.glob, casemapping.Fold(target.RealName))
+	default:
+		return false
+	}
+}
@@ -0,0 +1,139 @@
+package ircutils
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+func TestCasemapping(t *testing.T) {
+	assertEqual(ParseCasemapping(""), CasemappingRFC1459)
+	assertEqual(ParseCasemapping("rfc1459"), CasemappingRFC1459)
+	assertEqual(ParseCasemapping("ascii"), CasemappingASCII)
+	assertEqual(ParseCasemapping("rfc1459-strict"), CasemappingRFC1459Strict)
+	assertEqual(CasemappingRFC1459Strict.String(), "rfc1459-strict")
+
+	assertEqual(CasemappingASCII.Fold("Dan[]\\^"), "dan[]\\^")
+	assertEqual(CasemappingRFC1459.Fold("Dan[]\\^"), "dan{}|~")
+	assertEqual(CasemappingRFC1459Strict.Fold("Dan[]\\^"), "dan{}|^")
+	assertEqual(CasemappingRFC1459.Fold("dan"), "dan")
+	assertEqual(CasemappingRFC1459.Fold("ŞIVARAM"), "Şivaram")
+
+	assertEqual(CasemappingRFC1459.Equal("Dan[a]", "dan{A}"), true)
+	assertEqual(CasemappingASCII.Equal("Dan[a]", "dan{A}"), false)
+	assertEqual(CasemappingRFC1459.Equal("dan", "dan_"), false)
+}
+
+func TestMatchGlob(t *testing.T) {
+	globs := []string{"", "*", "?", "a*", "*a", "a*b*c", "a?c", "?🐬?", "*.example.com", "**a**", "a\\*"}
+	inputs := []string{"", "a", "ab", "abc", "aXbYc", "acb", "x🐬y", "🐬", "irc.example.com", "example.com", "a\\bc", "a.c"}
+	for _, glob := range globs {
+		re, err := CompileGlob(glob, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, input := range inputs {
+			if MatchGlob(glob, input) != re.MatchString(input) {
+				t.Errorf("glob %q on input %q: MatchGlob returned %t", glob, input, !re.MatchString(input))
+			}
+		}
+	}
+
+	re, _ := CompileGlob("b?c", true)
+	assertEqual(re.MatchString("abdcd"), true)
+}
+
+func TestCanonicalizeMask(t *testing.T) {
+	assertEqual(CanonicalizeMask("dan"), "dan!*@*")
+	assertEqual(CanonicalizeMask("*@example.com"), "*!*@example.com")
+	assertEqual(CanonicalizeMask("dan!d"), "dan!d@*")
+	assertEqual(CanonicalizeMask("!d@"), "*!d@*")
+	assertEqual(CanonicalizeMask("*!*@*.example.com"), "*!*@*.example.com")
+	assertEqual(CanonicalizeMask("a@b!c"), "a@b!c@*")
+}
+
+func TestMatchMask(t *testing.T) {
+	nuh := ircmsg.NUH{Name: "Dan[]", User: "~d", Host: "irc.example.com"}
+	assertEqual(MatchMask("*!*@*.example.com", nuh, CasemappingRFC1459), true)
+	assertEqual(MatchMask("*!*@*.EXAMPLE.com", nuh, CasemappingRFC1459), true)
+	assertEqual(MatchMask("*!*@example.com", nuh, CasemappingRFC1459), false)
+	assertEqual(MatchMask("dan{}", nuh, CasemappingRFC1459), true)
+	assertEqual(MatchMask("dan{}", nuh, CasemappingASCII), false)
+	assertEqual(MatchMask("dan??", nuh, CasemappingASCII), true)
+	assertEqual(MatchMask("dan?", nuh, CasemappingASCII), false)
+	assertEqual(MatchMask("*!?d@*", nuh, CasemappingASCII), true)
+	// regexp metacharacters are literal:
+	assertEqual(MatchMask("d.n*", nuh, CasemappingASCII), false)
+}
+
+func TestMaskSet(t *testing.T) {
+	set := NewMaskSet(CasemappingRFC1459)
+	assertEqual(set.Add("*!*@*.example.com"), nil)
+	assertEqual(set.Add("Bad[Guy]"), nil)
+	assertEqual(set.Add("*!*@192.0.2.0/24"), nil)
+	assertEqual(set.Add("*!~evil@2001:db8::/32"), nil)
+	assertEqual(set.Add("$a:spam*"), nil)
+	assertEqual(set.Add("$r:*free bitcoin*"), nil)
+	assertEqual(set.Add(""), ErrInvalidMask)
+	assertEqual(set.Add("$a:"), ErrInvalidMask)
+	assertEqual(set.Add("$z:asdf"), ErrUnknownExtban)
+	assertEqual(set.Len(), 6)
+
+	check := func(target MaskTarget, expected string) {
+		mask, matched := set.FindMatch(target)
+		if mask != expected || matched != (expected != "") {
+			t.Errorf("matching %#v: expected %q, got %q", target, expected, mask)
+		}
+	}
+
+	check(MaskTarget{NUH: ircmsg.NUH{Name: "dan", User: "d", Host: "irc.example.com"}}, "*!*@*.example.com")
+	check(MaskTarget{NUH: ircmsg.NUH{Name: "dan", User: "d", Host: "example.com"}}, "")
+	check(MaskTarget{NUH: ircmsg.NUH{Name: "badguy", User: "d", Host: "example.com"}}, "")
+	check(MaskTarget{NUH: ircmsg.NUH{Name: "bad{guy}", User: "d", Host: "example.com"}}, "Bad[Guy]")
+	check(MaskTarget{NUH: ircmsg.NUH{Name: "dan", User: "d", Host: "192.0.2.7"}}, "*!*@192.0.2.0/24")
+	check(MaskTarget{NUH: ircmsg.NUH{Name: "dan", User: "d", Host: "192.0.3.7"}}, "")
+	check(MaskTarget{NUH: ircmsg.NUH{Name: "dan", User: "d", Host: "cloaked"}, IP: net.ParseIP("192.0.2.200")}, "*!*@192.0.2.0/24")
+	check(MaskTarget{NUH: ircmsg.NUH{Name: "dan", User: "~evil", Host: "2001:db8::1"}}, "*!~evil@2001:db8::/32")
+	check(MaskTarget{NUH: ircmsg.NUH{Name: "dan", User: "~good", Host: "2001:db8::1"}}, "")
+	check(MaskTarget{NUH: ircmsg.NUH{Name: "dan", User: "d", Host: "example.com"}, Account: "SpamBot"}, "$a:spam*")
+	check(MaskTarget{NUH: ircmsg.NUH{Name: "dan", User: "d", Host: "example.com"}, Account: "dan"}, "")
+	check(MaskTarget{NUH: ircmsg.NUH{Name: "dan", User: "d", Host: "example.com"}, RealName: "get free bitcoin now"}, "$r:*free bitcoin*")
+
+	set.Remove("*!*@192.0.2.0/24")
+	set.Remove("Bad[Guy]")
+	set.Remove("$a:spam*")
+	set.Remove("not in the set")
+	assertEqual(set.Masks(), []string{"$r:*free bitcoin*", "*!*@*.example.com", "*!~evil@2001:db8::/32"})
+	check(MaskTarget{NUH: ircmsg.NUH{Name: "bad{guy}", User: "d", Host: "example.com"}}, "")
+	check(MaskTarget{NUH: ircmsg.NUH{Name: "dan", User: "d", Host: "192.0.2.7"}}, "")
+	check(MaskTarget{NUH: ircmsg.NUH{Name: "dan", User: "d", Host: "example.com"}, Account: "SpamBot"}, "")
+	check(MaskTarget{NUH: ircmsg.NUH{Name: "dan", User: "d", Host: "irc.example.com"}}, "*!*@*.example.com")
+
+	// masks that are equivalent after casefolding are tracked separately:
+	assertEqual(set.Add("dan"), nil)
+	assertEqual(set.Add("DAN!*@*"), nil)
+	set.Remove("dan")
+	check(MaskTarget{NUH: ircmsg.NUH{Name: "Dan", User: "d", Host: "example.com"}}, "DAN!*@*")
+
+	// negation, and bare $a:
+	set.Initialize(CasemappingASCII)
+	assertEqual(set.Add("$~a"), nil)
+	check(MaskTarget{NUH: ircmsg.NUH{Name: "dan", User: "d", Host: "example.com"}}, "$~a")
+	check(MaskTarget{NUH: ircmsg.NUH{Name: "dan", User: "d", Host: "example.com"}, Account: "dan"}, "")
+	assertEqual(set.Match(MaskTarget{NUH: ircmsg.NUH{Name: "dan"}}), true)
+}
+
+func BenchmarkMaskSet(b *testing.B) {
+	set := NewMaskSet(CasemappingRFC1459)
+	for i := 0; i < 5000; i++ {
+		set.Add(fmt.Sprintf("*!*@*.host%d.example.com", i))
+	}
+	target := MaskTarget{NUH: ircmsg.NUH{Name: "dan", User: "d", Host: "irc.example.com"}}
+	set.Match(target)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		set.Match(target)
+	}
+}
@@ -0,0 +1,231 @@
+package ircutils
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+var (
+	ErrInvalidChanModes  = errors.New("invalid CHANMODES or PREFIX value")
+	ErrMissingModeParam  = errors.New("mode change is missing a required parameter")
+	ErrInvalidModeString = errors.New("mode string must begin with + or -")
+	ErrInvalidModeParam  = errors.New("mode parameter cannot contain a space or begin with :")
+)
+
+const (
+	// defaults when CHANMODES and PREFIX are not advertised by the server
+	DefaultChanModes = "b,k,l,imnpst"
+	DefaultPrefix    = "(ov)@+"
+	// default value of the ISUPPORT MODES token, when it is not advertised
+	DefaultModesLimit = 3
+)
+
+// ChannelModes describes which channel modes take parameters, as advertised
+// by the server in the CHANMODES and PREFIX tokens of RPL_ISUPPORT. The zero
+// value has no modes that take parameters (which is appropriate for parsing
+// user modes).
+type ChannelModes struct {
+	// Type A: modes that add or remove an address from a list; these always
+	// take a parameter, except that an unparameterized +b is a list query.
+	ListModes string
+	// Type B: modes that always take a parameter (e.g., k).
+	ParamModes string
+	// Type C: modes that take a parameter only when set (e.g., l).
+	SetParamModes string
+	// Type D: modes that never take a parameter.
+	FlagModes string
+	// Membership modes from PREFIX (e.g. "ov"), which always take a nickname,
+	// and their corresponding prefix symbols (e.g. "@+"), in descending order.
+	PrefixModes   string
+	PrefixSymbols string
+}
+
+// ParseChannelModes parses the values of the CHANMODES and PREFIX
+// ISUPPORT tokens; empty values are replaced by DefaultChanModes and
+// DefaultPrefix respectively.
+func ParseChannelModes(chanmodes, prefix string) (result ChannelModes, err error) {
+	if chanmodes == "" {
+		chanmodes = DefaultChanModes
+	}
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	// "... there may be additional types added in the future; if so, they
+	//  must be treated as type D" (any extra commas are ignored)
+	types := strings.Split(chanmodes, ",")
+	if len(types) < 4 {
+		return result, ErrInvalidChanModes
+	}
+	result.ListModes, result.ParamModes, result.SetParamModes, result.FlagModes = types[0], types[1], types[2], types[3]
+
+	if prefix[0] != '(' {
+		return result, ErrInvalidChanModes
+	}
+	closeIdx := strings.IndexByte(prefix, ')')
+	if closeIdx == -1 || len(prefix)-closeIdx-1 != closeIdx-1 {
+		return result, ErrInvalidChanModes
+	}
+	result.PrefixModes, result.PrefixSymbols = prefix[1:closeIdx], prefix[closeIdx+1:]
+	return result, nil
+}
+
+// TakesParam returns whether a mode takes a parameter when it is being
+// added (if adding is true) or removed.
+func (cm *ChannelModes) TakesParam(mode byte, adding bool) bool {
+	switch {
+	case strings.IndexByte(cm.PrefixModes, mode) != -1,
+		strings.IndexByte(cm.ListModes, mode) != -1,
+		strings.IndexByte(cm.ParamModes, mode) != -1:
+		return true
+	case strings.IndexByte(cm.SetParamModes, mode) != -1:
+		return adding
+	default:
+		return false
+	}
+}
+
+// ModeOp is the direction of a mode change.
+type ModeOp byte
+
+const (
+	ModeAdd    ModeOp = '+'
+	ModeRemove ModeOp = '-'
+)
+
+// ModeChange is a single mode being added or removed, with its parameter,
+// if any.
+type ModeChange struct {
+	Op   ModeOp
+	Mode byte
+	Arg  string
+}
+
+// String returns a human-readable representation of the change, e.g. "+o dan".
+func (change ModeChange) String() string {
+	var buf strings.Builder
+	buf.WriteByte(byte(change.Op))
+	buf.WriteByte(change.Mode)
+	if change.Arg != "" {
+		buf.WriteByte(' ')
+		buf.WriteString(change.Arg)
+	}
+	return buf.String()
+}
+
+// ParseModeChanges parses the parameters of a MODE command (after the
+// target), e.g. ["+ov-b", "dan", "shivaram", "*!*@bad"], into individual mode
+// changes. Multiple mode strings may appear, each followed by the
+// parameters for its modes. A list mode without a parameter (e.g. "+b")
+// is included with an empty Arg; it is a query for the contents of the list.
+// If a required parameter is missing, the changes parsed so far are
+// returned along with ErrMissingModeParam; parameters left over after the
+// last mode string are ignored. A nil modes means that no mode takes a
+// parameter, as for user modes.
+func ParseModeChanges(modes *ChannelModes, params ...string) (changes []ModeChange, err error) {
+	if modes == nil {
+		modes = &ChannelModes{}
+	}
+	for first := true; len(params) != 0; first = false {
+		modestring := params[0]
+		params = params[1:]
+		op := ModeAdd
+		if len(modestring) == 0 || !(modestring[0] == '+' || modestring[0] == '-') {
+			if first {
+				return changes, ErrInvalidModeString
+			}
+			// excess parameters sent by the server
+			return
+		}
+		for i := 0; i < len(modestring); i++ {
+			mode := modestring[i]
+			if mode == '+' || mode == '-' {
+				op = ModeOp(mode)
+				continue
+			}
+			change := ModeChange{Op: op, Mode: mode}
+			if modes.TakesParam(mode, op == ModeAdd) {
+				if len(params) != 0 {
+					change.Arg = params[0]
+					params = params[1:]
+				} else if strings.IndexByte(modes.ListModes, mode) == -1 {
+					return changes, ErrMissingModeParam
+				}
+			}
+			changes = append(changes, change)
+		}
+	}
+	return
+}
+
+// BuildModeMessages packs mode changes (in order) into as few MODE commands
+// as possible. A change takes a parameter if and only if its Arg is non-empty.
+// maxModes is the maximum number of changes with parameters per command, as
+// advertised by the server in the MODES ISUPPORT token (0 for no limit; if the
+// token is not advertised, use DefaultModesLimit). maxLineLen is the maximum
+// length of each line, including the trailing \r\n (0 for no limit);
+// when sending to a server that will relay the command to other clients,
+// it should include an allowance for the source the server will add.
+func BuildModeMessages(target string, changes []ModeChange, maxModes, maxLineLen int) (result []ircmsg.Message, err error) {
+	for _, change := range changes {
+		if strings.IndexByte(change.Arg, ' ') != -1 || strings.HasPrefix(change.Arg, ":") {
+			return nil, ErrInvalidModeParam
+		}
+	}
+
+	// "MODE " + target + " " + modestring + (" " + arg)* + "\r\n"
+	baseLen := len("MODE ") + len(target) + len(" ") + len("\r\n")
+
+	var modestring strings.Builder
+	var args []string
+	var lineLen, numArgs int
+	var currentOp ModeOp
+
+	flush := func() {
+		if modestring.Len() != 0 {
+			params := make([]string, 0, len(args)+2)
+			params = append(params, target, modestring.String())
+			params = append(params, args...)
+			result = append(result, ircmsg.MakeMessage(nil, "", "MODE", params...))
+		}
+		modestring.Reset()
+		args = nil
+		lineLen = baseLen
+		numArgs = 0
+		currentOp = 0
+	}
+	flush()
+
+	for _, change := range changes {
+		for attempt := 0; attempt < 2; attempt++ {
+			added := 1 // the mode character
+			if change.Op != currentOp {
+				added++
+			}
+			if change.Arg != "" {
+				added += 1 + len(change.Arg)
+			}
+			tooManyModes := change.Arg != "" && maxModes > 0 && numArgs >= maxModes
+			tooLong := maxLineLen > 0 && lineLen+added > maxLineLen
+			if (tooManyModes || tooLong) && modestring.Len() != 0 {
+				// doesn't fit, start a new line and try again
+				flush()
+				continue
+			}
+			if change.Op != currentOp {
+				modestring.WriteByte(byte(change.Op))
+				currentOp = change.Op
+			}
+			modestring.WriteByte(change.Mode)
+			if change.Arg != "" {
+				args = append(args, change.Arg)
+				numArgs++
+			}
+			lineLen += added
+			break
+		}
+	}
+	flush()
+	return result, nil
+}
@@ -0,0 +1,122 @@
+package ircutils
+
+import (
+	"testing"
+)
+
+func TestParseChannelModes(t *testing.T) {
+	modes, err := ParseChannelModes("beI,k,l,imnpst,X", "(qaohv)~&@%+")
+	assertEqual(err, nil)
+	assertEqual(modes, ChannelModes{
+		ListModes:     "beI",
+		ParamModes:    "k",
+		SetParamModes: "l",
+		FlagModes:     "imnpst",
+		PrefixModes:   "qaohv",
+		PrefixSymbols: "~&@%+",
+	})
+
+	modes, err = ParseChannelModes("", "")
+	assertEqual(err, nil)
+	assertEqual(modes.PrefixModes, "ov")
+	assertEqual(modes.ListModes, "b")
+
+	_, err = ParseChannelModes("b,k,l", "")
+	assertEqual(err, ErrInvalidChanModes)
+	_, err = ParseChannelModes("", "(ov)@")
+	assertEqual(err, ErrInvalidChanModes)
+	_, err = ParseChannelModes("", "ov@+")
+	assertEqual(err, ErrInvalidChanModes)
+}
+
+func TestParseModeChanges(t *testing.T) {
+	modes, _ := ParseChannelModes("beI,k,l,imnpst", "(ov)@+")
+
+	changes, err := ParseModeChanges(&modes, "+ov-bl+kn", "dan", "shivaram", "*!*@bad", "hunter2")
+	assertEqual(err, nil)
+	assertEqual(changes, []ModeChange{
+		{ModeAdd, 'o', "dan"},
+		{ModeAdd, 'v', "shivaram"},
+		{ModeRemove, 'b', "*!*@bad"},
+		{ModeRemove, 'l', ""},
+		{ModeAdd, 'k', "hunter2"},
+		{ModeAdd, 'n', ""},
+	})
+	assertEqual(changes[0].String(), "+o dan")
+	assertEqual(changes[3].String(), "-l")
+
+	// multiple mode strings, and a list query:
+	changes, err = ParseModeChanges(&modes, "+l", "50", "-t+b")
+	assertEqual(err, nil)
+	assertEqual(changes, []ModeChange{{ModeAdd, 'l', "50"}, {ModeRemove, 't', ""}, {ModeAdd, 'b', ""}})
+
+	changes, err = ParseModeChanges(&modes, "+mk")
+	assertEqual(err, ErrMissingModeParam)
+	assertEqual(changes, []ModeChange{{ModeAdd, 'm', ""}})
+
+	_, err = ParseModeChanges(&modes, "o", "dan")
+	assertEqual(err, ErrInvalidModeString)
+
+	// extra parameters are ignored:
+	changes, err = ParseModeChanges(&modes, "+o-n", "dan", "extra", "-t")
+	assertEqual(err, nil)
+	assertEqual(changes, []ModeChange{{ModeAdd, 'o', "dan"}, {ModeRemove, 'n', ""}})
+
+	// user modes:
+	changes, err = ParseModeChanges(&ChannelModes{}, "+iw-o")
+	assertEqual(err, nil)
+	assertEqual(len(changes), 3)
+	changes, err = ParseModeChanges(nil, "+i", "-w")
+	assertEqual(err, nil)
+	assertEqual(changes, []ModeChange{{ModeAdd, 'i', ""}, {ModeRemove, 'w', ""}})
+}
+
+func TestBuildModeMessages(t *testing.T) {
+	lines := func(changes []ModeChange, maxModes, maxLineLen int) (result []string) {
+		msgs, err := BuildModeMessages("#chat", changes, maxModes, maxLineLen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, msg := range msgs {
+			line, err := msg.LineBytesStrict(false, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if maxLineLen != 0 && len(line) > maxLineLen {
+				t.Errorf("line %q exceeds %d bytes", line, maxLineLen)
+			}
+			result = append(result, string(line[:len(line)-2]))
+		}
+		return
+	}
+
+	changes := []ModeChange{
+		{ModeAdd, 'o', "dan"},
+		{ModeAdd, 'o', "shivaram"},
+		{ModeAdd, 'n', ""},
+		{ModeRemove, 'v', "dan"},
+		{ModeRemove, 'v', "slingamn"},
+		{ModeAdd, 't', ""},
+	}
+	assertEqual(lines(changes, 0, 0), []string{"MODE #chat +oon-vv+t dan shivaram dan slingamn"})
+	assertEqual(lines(changes, 3, 0), []string{"MODE #chat +oon-v dan shivaram dan", "MODE #chat -v+t slingamn"})
+	assertEqual(lines(changes, 1, 0), []string{
+		"MODE #chat +o dan",
+		"MODE #chat +on shivaram",
+		"MODE #chat -v dan",
+		"MODE #chat -v+t slingamn",
+	})
+	assertEqual(lines(changes, 0, 40), []string{"MODE #chat +oon-v dan shivaram dan", "MODE #chat -v+t slingamn"})
+	assertEqual(lines(nil, 3, 0), []string(nil))
+
+	// a parsed change list round-trips:
+	modes, _ := ParseChannelModes("", "")
+	parsed, err := ParseModeChanges(&modes, "+oon-vv+t", "dan", "shivaram", "dan", "slingamn")
+	assertEqual(err, nil)
+	assertEqual(parsed, changes)
+
+	_, err = BuildModeMessages("#chat", []ModeChange{{ModeAdd, 'k', "hunter 2"}}, 3, 0)
+	assertEqual(err, ErrInvalidModeParam)
+	_, err = BuildModeMessages("#chat", []ModeChange{{ModeAdd, 'k', ":hunter2"}}, 3, 0)
+	assertEqual(err, ErrInvalidModeParam)
+}
@@ -0,0 +1,251 @@
+package ircutils
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	ErrNameEmpty        = errors.New("name is empty")
+	ErrNameTooLong      = errors.New("name is too long")
+	ErrNameInvalidChar  = errors.New("name contains an invalid character")
+	ErrNameInvalidStart = errors.New("name begins with an invalid character")
+)
+
+const (
+	// defaults when CHANTYPES and PREFIX are not advertised by the server
+	DefaultChanTypes     = "#&"
+	DefaultPrefixSymbols = "@+"
+)
+
+const (
+	rfc1459NickSpecials   = "[]\\`_^{|}"
+	rfc1459NickChars      = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-" + rfc1459NickSpecials
+	modernForbiddenInName = " ,*?!@"
+)
+
+// NicknameRules selects the character rules applied to nicknames
+// and account names.
+type NicknameRules uint
+
+const (
+	// NicknameRulesModern forbids only the characters that are ambiguous
+	// in the protocol, as per https://modern.ircdocs.horse/#clients
+	NicknameRulesModern NicknameRules = iota
+	// NicknameRulesRFC1459 allows only ASCII letters, digits, hyphens and
+	// []\`_^{|}, and does not allow a digit or hyphen as the first character.
+	NicknameRulesRFC1459
+)
+
+// NameLimits describes the constraints a server places on names, as
+// advertised in RPL_ISUPPORT. The zero value imposes no length limits,
+// applies the modern nickname rules, and uses the default CHANTYPES and
+// PREFIX symbols. The Validate functions treat a nil *NameLimits as the
+// zero value.
+type NameLimits struct {
+	NickLen    int // NICKLEN; 0 for no limit
+	UserLen    int // USERLEN; 0 for no limit
+	ChannelLen int // CHANNELLEN; 0 for no limit
+	AccountLen int // ACCOUNTLEN; 0 to use NickLen
+	// ChanTypes is the value of CHANTYPES (default "#&").
+	ChanTypes string
+	// PrefixSymbols are the channel membership prefixes from PREFIX (default "@+").
+	PrefixSymbols string
+	// NicknameRules selects the rules for nickname and account name characters.
+	NicknameRules NicknameRules
+	// UTF8 allows non-ASCII characters (which must be valid UTF-8) in
+	// nicknames and account names; usernames and channel names may always
+	// contain them.
+	// No ISUPPORT token advertises this (in particular, UTF8ONLY only requires
+	// message text to be valid UTF-8), so ParseNameLimits leaves it unset.
+	UTF8 bool
+}
+
+// ParseNameLimits returns the NameLimits corresponding to a set of ISUPPORT
+// tokens, e.g. as returned by (*ircevent.Connection).ISupport().
+func ParseNameLimits(isupport map[string]string) (limits NameLimits) {
+	parseLen := func(token string) int {
+		value, _ := strconv.Atoi(isupport[token])
+		if value < 0 {
+			return 0
+		}
+		return value
+	}
+	limits.NickLen = parseLen("NICKLEN")
+	limits.UserLen = parseLen("USERLEN")
+	limits.ChannelLen = parseLen("CHANNELLEN")
+	limits.AccountLen = parseLen("ACCOUNTLEN")
+	if chantypes, ok := isupport["CHANTYPES"]; ok {
+		// an empty value means the server has no channels, so use a
+		// placeholder that no name can begin with
+		if chantypes == "" {
+			chantypes = "\x00"
+		}
+		limits.ChanTypes = chantypes
+	}
+	if prefix := isupport["PREFIX"]; prefix != "" {
+		if closeIdx := strings.IndexByte(prefix, ')'); closeIdx != -1 {
+			limits.PrefixSymbols = prefix[closeIdx+1:]
+		}
+	}
+	return
+}
+
+func (limits *NameLimits) chanTypes() string {
+	if limits.ChanTypes == "" {
+		return DefaultChanTypes
+	}
+	return limits.ChanTypes
+}
+
+func (limits *NameLimits) prefixSymbols() string {
+	if limits.PrefixSymbols == "" {
+		return DefaultPrefixSymbols
+	}
+	return limits.PrefixSymbols
+}
+
+// checkControlChars returns ErrNameInvalidChar if name contains control
+// characters.
+func checkControlChars(name string) error {
+	for i := 0; i < len(name); i++ {
+		if name[i] < 0x20 || name[i] == 0x7f {
+			return ErrNameInvalidChar
+		}
+	}
+	return nil
+}
+
+// checkChars returns ErrNameInvalidChar if name contains control
+// characters, or non-ASCII characters that are not allowed.
+func (limits *NameLimits) checkChars(name string) error {
+	if err := checkControlChars(name); err != nil {
+		return err
+	}
+	if !limits.UTF8 {
+		for i := 0; i < len(name); i++ {
+			if name[i] >= utf8.RuneSelf {
+				return ErrNameInvalidChar
+			}
+		}
+	} else {
+		if !utf8.ValidString(name) {
+			return ErrNameInvalidChar
+		}
+		for _, r := range name {
+			if unicode.IsSpace(r) || unicode.IsControl(r) {
+				return ErrNameInvalidChar
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateNickname checks whether a nickname is acceptable to the server,
+// returning nil or one of the ErrName errors.
+func ValidateNickname(nick string, limits *NameLimits) error {
+	if limits == nil {
+		limits = &NameLimits{}
+	}
+	return validateNicknameWithLen(nick, limits, limits.NickLen)
+}
+
+// ValidateAccountName checks whether an account name is acceptable to the
+// server; account names follow the same rules as nicknames.
+func ValidateAccountName(account string, limits *NameLimits) error {
+	if limits == nil {
+		limits = &NameLimits{}
+	}
+	maxLen := limits.AccountLen
+	if maxLen == 0 {
+		maxLen = limits.NickLen
+	}
+	return validateNicknameWithLen(account, limits, maxLen)
+}
+
+func validateNicknameWithLen(nick string, limits *NameLimits, maxLen int) error {
+	if nick == "" {
+		return ErrNameEmpty
+	}
+	if maxLen != 0 && len(nick) > maxLen {
+		return ErrNameTooLong
+	}
+	if limits.NicknameRules == NicknameRulesRFC1459 {
+		for i := 0; i < len(nick); i++ {
+			if strings.IndexByte(rfc1459NickChars, nick[i]) == -1 {
+				return ErrNameInvalidChar
+			}
+		}
+		if first := nick[0]; first == '-' || ('0' <= first && first <= '9') {
+			return ErrNameInvalidStart
+		}
+		return nil
+	}
+
+	first := nick[0]
+	if first == '$' || first == ':' || strings.IndexByte(limits.chanTypes(), first) != -1 ||
+		strings.IndexByte(limits.prefixSymbols(), first) != -1 {
+		return ErrNameInvalidStart
+	}
+	if err := limits.checkChars(nick); err != nil {
+		return err
+	}
+	if strings.ContainsAny(nick, modernForbiddenInName) {
+		return ErrNameInvalidChar
+	}
+	return nil
+}
+
+// ValidateUsername checks whether a username (as sent in the USER command)
+// is acceptable to the server. Non-ASCII characters are allowed.
+func ValidateUsername(username string, limits *NameLimits) error {
+	if limits == nil {
+		limits = &NameLimits{}
+	}
+	if username == "" {
+		return ErrNameEmpty
+	}
+	if limits.UserLen != 0 && len(username) > limits.UserLen {
+		return ErrNameTooLong
+	}
+	if err := checkControlChars(username); err != nil {
+		return err
+	}
+	if strings.ContainsAny(username, " @!") {
+		return ErrNameInvalidChar
+	}
+	if username[0] == ':' {
+		return ErrNameInvalidStart
+	}
+	return nil
+}
+
+// ValidateChannelName checks whether a channel name is acceptable to the
+// server. It must begin with one of the CHANTYPES characters (otherwise
+// ErrNameInvalidStart is returned), and may not contain spaces, commas,
+// colons, or control characters such as BEL (^G). As in RFC 2812, any
+// other bytes (including non-ASCII characters) are allowed.
+func ValidateChannelName(channel string, limits *NameLimits) error {
+	if limits == nil {
+		limits = &NameLimits{}
+	}
+	if channel == "" {
+		return ErrNameEmpty
+	}
+	if limits.ChannelLen != 0 && len(channel) > limits.ChannelLen {
+		return ErrNameTooLong
+	}
+	if strings.IndexByte(limits.chanTypes(), channel[0]) == -1 {
+		return ErrNameInvalidStart
+	}
+	if err := checkControlChars(channel); err != nil {
+		return err
+	}
+	if strings.ContainsAny(channel, " ,:") {
+		return ErrNameInvalidChar
+	}
+	return nil
+}
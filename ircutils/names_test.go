@@ -0,0 +1,114 @@
+package ircutils
+
+import (
+	"testing"
+)
+
+func TestParseNameLimits(t *testing.T) {
+	limits := ParseNameLimits(map[string]string{
+		"NICKLEN":    "16",
+		"CHANNELLEN": "64",
+		"USERLEN":    "x",
+		"CHANTYPES":  "#",
+		"PREFIX":     "(qov)~@+",
+		"UTF8ONLY":   "",
+	})
+	// UTF8ONLY says nothing about the characters allowed in names:
+	assertEqual(limits, NameLimits{NickLen: 16, ChannelLen: 64, ChanTypes: "#", PrefixSymbols: "~@+"})
+
+	limits = ParseNameLimits(nil)
+	assertEqual(limits, NameLimits{})
+	assertEqual(ValidateChannelName("&local", &limits), nil)
+
+	limits = ParseNameLimits(map[string]string{"CHANTYPES": ""})
+	assertEqual(ValidateChannelName("#chat", &limits), ErrNameInvalidStart)
+}
+
+func TestValidateNickname(t *testing.T) {
+	limits := NameLimits{NickLen: 9}
+	assertEqual(ValidateNickname("dan", &limits), nil)
+	assertEqual(ValidateNickname("[dan]`^_|", &limits), nil)
+	assertEqual(ValidateNickname("1dan", &limits), nil)
+	assertEqual(ValidateNickname("", &limits), ErrNameEmpty)
+	assertEqual(ValidateNickname("shivaram_", &limits), nil)
+	assertEqual(ValidateNickname("shivaram__", &limits), ErrNameTooLong)
+	for _, nick := range []string{"da n", "dan,", "dan*", "dan?", "dan!", "d@n", "da\x00n", "dan\x07"} {
+		assertEqual(ValidateNickname(nick, &limits), ErrNameInvalidChar)
+	}
+	for _, nick := range []string{"#dan", "&dan", "$dan", ":dan", "@dan", "+dan"} {
+		assertEqual(ValidateNickname(nick, &limits), ErrNameInvalidStart)
+	}
+	assertEqual(ValidateNickname("~dan", &limits), nil)
+	limits.PrefixSymbols = "~@+"
+	assertEqual(ValidateNickname("~dan", &limits), ErrNameInvalidStart)
+
+	// UTF-8:
+	assertEqual(ValidateNickname("şivaram", &limits), ErrNameInvalidChar)
+	limits.UTF8 = true
+	assertEqual(ValidateNickname("şivaram", &limits), nil)
+	assertEqual(ValidateNickname("ş\xffvaram", &limits), ErrNameInvalidChar)
+	assertEqual(ValidateNickname("ş v", &limits), ErrNameInvalidChar)
+
+	// RFC 1459:
+	limits = NameLimits{NicknameRules: NicknameRulesRFC1459}
+	assertEqual(ValidateNickname("[dan]`^_|-9", &limits), nil)
+	assertEqual(ValidateNickname("1dan", &limits), ErrNameInvalidStart)
+	assertEqual(ValidateNickname("-dan", &limits), ErrNameInvalidStart)
+	assertEqual(ValidateNickname("dan.", &limits), ErrNameInvalidChar)
+	assertEqual(ValidateNickname("dan~", &limits), ErrNameInvalidChar)
+}
+
+func TestValidateAccountName(t *testing.T) {
+	limits := NameLimits{NickLen: 5}
+	assertEqual(ValidateAccountName("dan", &limits), nil)
+	assertEqual(ValidateAccountName("slingamn", &limits), ErrNameTooLong)
+	limits.AccountLen = 10
+	assertEqual(ValidateAccountName("slingamn", &limits), nil)
+	assertEqual(ValidateAccountName("*", &limits), ErrNameInvalidChar)
+}
+
+func TestValidateUsername(t *testing.T) {
+	limits := NameLimits{UserLen: 10}
+	assertEqual(ValidateUsername("~dan", &limits), nil)
+	assertEqual(ValidateUsername("", &limits), ErrNameEmpty)
+	assertEqual(ValidateUsername("shivaram123", &limits), ErrNameTooLong)
+	assertEqual(ValidateUsername("d@n", &limits), ErrNameInvalidChar)
+	assertEqual(ValidateUsername("d n", &limits), ErrNameInvalidChar)
+	assertEqual(ValidateUsername("d\rn", &limits), ErrNameInvalidChar)
+	assertEqual(ValidateUsername(":dan", &limits), ErrNameInvalidStart)
+	assertEqual(ValidateUsername("şiva", &limits), nil)
+}
+
+func TestValidateChannelName(t *testing.T) {
+	limits := NameLimits{ChannelLen: 10, ChanTypes: "#"}
+	assertEqual(ValidateChannelName("#chat", &limits), nil)
+	assertEqual(ValidateChannelName("#", &limits), nil)
+	assertEqual(ValidateChannelName("", &limits), ErrNameEmpty)
+	assertEqual(ValidateChannelName("#chat-chat-", &limits), ErrNameTooLong)
+	assertEqual(ValidateChannelName("&chat", &limits), ErrNameInvalidStart)
+	assertEqual(ValidateChannelName("chat", &limits), ErrNameInvalidStart)
+	assertEqual(ValidateChannelName("#c h", &limits), ErrNameInvalidChar)
+	assertEqual(ValidateChannelName("#c,h", &limits), ErrNameInvalidChar)
+	assertEqual(ValidateChannelName("#c\x07h", &limits), ErrNameInvalidChar)
+	assertEqual(ValidateChannelName("#c:h", &limits), ErrNameInvalidChar)
+	// non-ASCII characters are allowed regardless of UTF8:
+	assertEqual(ValidateChannelName("#café", &limits), nil)
+	assertEqual(ValidateChannelName("#çhat", &limits), nil)
+	limits.UTF8 = true
+	assertEqual(ValidateChannelName("#çhat", &limits), nil)
+
+	// UTF8ONLY doesn't affect channel names either:
+	limits = ParseNameLimits(map[string]string{"UTF8ONLY": ""})
+	assertEqual(ValidateChannelName("#café", &limits), nil)
+}
+
+func TestValidateNilLimits(t *testing.T) {
+	// a nil *NameLimits is equivalent to the zero value:
+	assertEqual(ValidateChannelName("#x", nil), nil)
+	assertEqual(ValidateChannelName("x", nil), ErrNameInvalidStart)
+	assertEqual(ValidateNickname("dan", nil), nil)
+	assertEqual(ValidateNickname("şivaram", nil), ErrNameInvalidChar)
+	assertEqual(ValidateAccountName("dan", nil), nil)
+	assertEqual(ValidateUsername("~dan", nil), nil)
+	assertEqual(ValidateUsername("d@n", nil), ErrNameInvalidChar)
+}
@@ -0,0 +1,172 @@
+package ircutils
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// Punycode (RFC 3492), as used by IDNA to represent Unicode labels in DNS.
+
+const (
+	punycodeBase        = 36
+	punycodeTMin        = 1
+	punycodeTMax        = 26
+	punycodeSkew        = 38
+	punycodeDamp        = 700
+	punycodeInitialBias = 72
+	punycodeInitialN    = 128
+)
+
+var (
+	errInvalidPunycode = errors.New("invalid punycode")
+)
+
+func punycodeAdapt(delta, numPoints int, first bool) int {
+	if first {
+		delta /= punycodeDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+	k := 0
+	for delta > ((punycodeBase-punycodeTMin)*punycodeTMax)/2 {
+		delta /= punycodeBase - punycodeTMin
+		k += punycodeBase
+	}
+	return k + (punycodeBase-punycodeTMin+1)*delta/(delta+punycodeSkew)
+}
+
+func punycodeThreshold(k, bias int) int {
+	if k <= bias {
+		return punycodeTMin
+	} else if k >= bias+punycodeTMax {
+		return punycodeTMax
+	}
+	return k - bias
+}
+
+func punycodeDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+func punycodeDigitValue(c byte) int {
+	switch {
+	case '0' <= c && c <= '9':
+		return int(c-'0') + 26
+	case 'a' <= c && c <= 'z':
+		return int(c - 'a')
+	case 'A' <= c && c <= 'Z':
+		return int(c - 'A')
+	default:
+		return -1
+	}
+}
+
+// punycodeEncode encodes a Unicode label (without the "xn--" prefix).
+func punycodeEncode(input string) string {
+	runes := []rune(input)
+	var out strings.Builder
+	for _, r := range runes {
+		if r < utf8.RuneSelf {
+			out.WriteByte(byte(r))
+		}
+	}
+	basic := out.Len()
+	handled := basic
+	if basic > 0 {
+		out.WriteByte('-')
+	}
+
+	n, delta, bias := punycodeInitialN, 0, punycodeInitialBias
+	for handled < len(runes) {
+		m := int(utf8.MaxRune) + 1
+		for _, r := range runes {
+			if int(r) >= n && int(r) < m {
+				m = int(r)
+			}
+		}
+		delta += (m - n) * (handled + 1)
+		n = m
+		for _, r := range runes {
+			if int(r) < n {
+				delta++
+			} else if int(r) == n {
+				q := delta
+				for k := punycodeBase; ; k += punycodeBase {
+					t := punycodeThreshold(k, bias)
+					if q < t {
+						break
+					}
+					out.WriteByte(punycodeDigit(t + (q-t)%(punycodeBase-t)))
+					q = (q - t) / (punycodeBase - t)
+				}
+				out.WriteByte(punycodeDigit(q))
+				bias = punycodeAdapt(delta, handled+1, handled == basic)
+				delta = 0
+				handled++
+			}
+		}
+		delta++
+		n++
+	}
+	return out.String()
+}
+
+// punycodeDecode decodes a Punycode label (without the "xn--" prefix).
+func punycodeDecode(input string) (string, error) {
+	var output []rune
+	rest := input
+	if b := strings.LastIndexByte(input, '-'); b != -1 {
+		for i := 0; i < b; i++ {
+			if input[i] >= utf8.RuneSelf {
+				return "", errInvalidPunycode
+			}
+			output = append(output, rune(input[i]))
+		}
+		rest = input[b+1:]
+	}
+
+	n, i, bias := punycodeInitialN, 0, punycodeInitialBias
+	// labels are at most 63 bytes, so the arithmetic can't overflow
+	// unless the input is too long to be a label
+	if len(input) > 63 {
+		return "", errInvalidPunycode
+	}
+	for pos := 0; pos < len(rest); {
+		oldi, w := i, 1
+		for k := punycodeBase; ; k += punycodeBase {
+			if pos >= len(rest) {
+				return "", errInvalidPunycode
+			}
+			digit := punycodeDigitValue(rest[pos])
+			pos++
+			if digit < 0 {
+				return "", errInvalidPunycode
+			}
+			i += digit * w
+			t := punycodeThreshold(k, bias)
+			if digit < t {
+				break
+			}
+			w *= punycodeBase - t
+			if i > utf8.MaxRune*(len(output)+1) {
+				return "", errInvalidPunycode
+			}
+		}
+		bias = punycodeAdapt(i-oldi, len(output)+1, oldi == 0)
+		n += i / (len(output) + 1)
+		i %= len(output) + 1
+		if n > utf8.MaxRune || (0xd800 <= n && n <= 0xdfff) {
+			return "", errInvalidPunycode
+		}
+		output = append(output, 0)
+		copy(output[i+1:], output[i:])
+		output[i] = rune(n)
+		i++
+	}
+	return string(output), nil
+}